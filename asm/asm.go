@@ -0,0 +1,249 @@
+// Package asm translates between core.VM bytecode and a line based mnemonic
+// format.
+//
+//	; comments run to the end of the line
+//	loop:                 ; a label names the offset of the next instruction
+//	    jumpdest
+//	    push 300          ; push picks the smallest push1/2/4/8/32
+//	    push2 0x012c      ; or give the width
+//	    push @loop        ; a label address, always a push2
+//	    pushbyte 'F'      ; a byte for pack
+//	    pushbytes "foo"   ; string literals use Go syntax
+//	    pushbytes 0x0102  ; or hex bytes
+//	    dup 1
+//	    .byte 0xff 0x00   ; raw bytes
+//
+// Labels do not emit a jumpdest, jump targets still need one.
+package asm
+
+import (
+	"blockchain/core"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// line is one parsed source line that emits bytes
+type line struct {
+	num     int
+	op      byte
+	raw     []byte // for .byte and pushbytes
+	value   *big.Int
+	label   string // label reference of a push
+	size    int
+	isBytes bool
+}
+
+// Assemble translates the mnemonic source into bytecode
+func Assemble(src string) ([]byte, error) {
+	labels := map[string]int{}
+	lines := []*line{}
+	offset := 0
+	for i, text := range strings.Split(src, "\n") {
+		num := i + 1
+		text = strings.TrimSpace(stripComment(text))
+		if text == "" {
+			continue
+		}
+		if name, ok := strings.CutSuffix(text, ":"); ok {
+			if !isIdent(name) {
+				return nil, fmt.Errorf("line %d: invalid label %q", num, name)
+			}
+			if _, ok := labels[name]; ok {
+				return nil, fmt.Errorf("line %d: label %s redefined", num, name)
+			}
+			labels[name] = offset
+			continue
+		}
+		l, err := parseLine(num, text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+		lines = append(lines, l)
+		offset += l.size
+	}
+
+	code := make([]byte, 0, offset)
+	for _, l := range lines {
+		if l.isBytes {
+			code = append(code, l.raw...)
+			continue
+		}
+		code = append(code, l.op)
+		info, _ := core.LookupOpcode(l.op)
+		switch {
+		case info.Immediate < 0:
+			code = append(code, byte(len(l.raw)))
+			code = append(code, l.raw...)
+		case info.Immediate > 0:
+			value := l.value
+			if l.label != "" {
+				addr, ok := labels[l.label]
+				if !ok {
+					return nil, fmt.Errorf("line %d: unknown label %s", l.num, l.label)
+				}
+				value = big.NewInt(int64(addr))
+			}
+			if value.BitLen() > 8*info.Immediate {
+				return nil, fmt.Errorf("line %d: %s does not fit %s", l.num, value, info.Name)
+			}
+			code = append(code, value.FillBytes(make([]byte, info.Immediate))...)
+		}
+	}
+	return code, nil
+}
+
+// pushes by width, push picks the first that fits
+var pushes = []string{"push1", "push2", "push4", "push8", "push32"}
+
+func parseLine(num int, text string) (*line, error) {
+	name, arg := text, ""
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		name, arg = text[:i], strings.TrimSpace(text[i:])
+	}
+	name = strings.ToLower(name)
+	l := &line{num: num}
+
+	if name == ".byte" {
+		for _, field := range strings.Fields(arg) {
+			v, err := parseNumber(field)
+			if err != nil || v.BitLen() > 8 {
+				return nil, fmt.Errorf("invalid byte %q", field)
+			}
+			l.raw = append(l.raw, byte(v.Uint64()))
+		}
+		l.isBytes, l.size = true, len(l.raw)
+		return l, nil
+	}
+
+	if name == "push" {
+		if arg == "" {
+			return nil, fmt.Errorf("push needs an operand")
+		}
+		name = "push2"
+		if !strings.HasPrefix(arg, "@") {
+			v, err := parseNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, push := range pushes {
+				name = push
+				op, _ := core.OpcodeByName(push)
+				if info, _ := core.LookupOpcode(op); v.BitLen() <= 8*info.Immediate {
+					break
+				}
+			}
+		}
+	}
+
+	op, ok := core.OpcodeByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction %q", name)
+	}
+	l.op = op
+	info, _ := core.LookupOpcode(op)
+	switch {
+	case info.Immediate == 0:
+		if arg != "" {
+			return nil, fmt.Errorf("%s takes no operand", name)
+		}
+		l.size = 1
+	case info.Immediate < 0:
+		raw, err := parseBytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > 255 {
+			return nil, fmt.Errorf("%s of %d bytes, at most 255", name, len(raw))
+		}
+		l.raw, l.size = raw, 2+len(raw)
+	default:
+		if label, ok := strings.CutPrefix(arg, "@"); ok {
+			if !isIdent(label) {
+				return nil, fmt.Errorf("invalid label %q", label)
+			}
+			l.label = label
+		} else {
+			v, err := parseNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			l.value = v
+		}
+		l.size = 1 + info.Immediate
+	}
+	return l, nil
+}
+
+// parseNumber reads a decimal or 0x hex number or a 'c' character
+func parseNumber(s string) (*big.Int, error) {
+	if strings.HasPrefix(s, "'") {
+		r, err := strconv.Unquote(s)
+		if err != nil || len(r) != 1 {
+			return nil, fmt.Errorf("invalid character %s", s)
+		}
+		return big.NewInt(int64(r[0])), nil
+	}
+	v, ok := new(big.Int).SetString(s, 0)
+	if !ok || v.Sign() < 0 {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+// parseBytes reads a quoted string or 0x hex bytes
+func parseBytes(s string) ([]byte, error) {
+	if strings.HasPrefix(s, `"`) {
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return []byte(str), nil
+	}
+	if hexStr, ok := strings.CutPrefix(s, "0x"); ok {
+		raw, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes %s: %w", s, err)
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("expected a string or 0x bytes, got %q", s)
+}
+
+// stripComment cuts a ; comment that is not inside a literal
+func stripComment(text string) string {
+	var quote rune
+	escaped := false
+	for i, r := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ';':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const counter = `
+; count down from 3, storing the counter under "n"
+    push 3
+loop:
+    jumpdest
+    dup 1
+    pushbytes "n"   ; the key
+    store
+    push 1
+    swap 1
+    minus           ; top - 1
+    dup 1
+    push @loop
+    jumpi
+    halt
+`
+
+func TestAssemble(t *testing.T) {
+	code, err := Assemble(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x20, 0x03,
+		0x1b,
+		0x25, 0x01,
+		0x2a, 0x01, 'n',
+		0x0f,
+		0x20, 0x01,
+		0x26, 0x01,
+		0x0e,
+		0x25, 0x01,
+		0x21, 0x00, 0x02,
+		0x1a,
+		0x1c,
+	}, code)
+}
+
+func TestAssembleLiterals(t *testing.T) {
+	for src, expected := range map[string][]byte{
+		"push 255":                {0x20, 0xff},
+		"push 256":                {0x21, 0x01, 0x00},
+		"push 0x10000":            {0x22, 0x00, 0x01, 0x00, 0x00},
+		"push 0x100000000":        {0x23, 0, 0, 0, 1, 0, 0, 0, 0},
+		"push2 7":                 {0x21, 0x00, 0x07},
+		"pushbyte 'F'":            {0x29, 'F'},
+		"pushbyte 0x46":           {0x29, 'F'},
+		`pushbytes "a;b\n"`:       {0x2a, 0x04, 'a', ';', 'b', '\n'},
+		"pushbytes 0xbeef":        {0x2a, 0x02, 0xbe, 0xef},
+		"PUSH1 1 ; upper case ok": {0x20, 0x01},
+		".byte 0xff 1":            {0xff, 0x01},
+		"end:\npush @end":         {0x21, 0x00, 0x00},
+	} {
+		code, err := Assemble(src)
+		assert.Nil(t, err, src)
+		assert.Equal(t, expected, code, src)
+	}
+
+	code, err := Assemble("push " + "0x" + strings.Repeat("ff", 32))
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{0x24}, bytes.Repeat([]byte{0xff}, 32)...), code)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, src := range []string{
+		"nope",
+		"push",
+		"push -1",
+		"push1 256",
+		"add 1",
+		"push @missing",
+		"a:\na:",
+		"1a:",
+		`pushbytes "open`,
+		"pushbytes foo",
+		"pushbytes 0x" + strings.Repeat("00", 256),
+		"push 0x1" + strings.Repeat("00", 32),
+		".byte 256",
+	} {
+		_, err := Assemble(src)
+		assert.NotNil(t, err, src)
+	}
+
+	_, err := Assemble("push 1\n\nbad")
+	assert.ErrorContains(t, err, "line 3")
+}
+
+func TestDisassemble(t *testing.T) {
+	code, err := Assemble(counter)
+	assert.Nil(t, err)
+	text := Disassemble(code)
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	assert.Len(t, lines, 12)
+	assert.Equal(t, "push1 0x03                       ; 0000: 2003 = 3", lines[0])
+	assert.Equal(t, `pushbytes "n"                    ; 0005: 2a016e`, lines[3])
+	assert.Equal(t, "push2 0x0002                     ; 0010: 210002 = 2", lines[9])
+
+	again, err := Assemble(text)
+	assert.Nil(t, err)
+	assert.Equal(t, code, again)
+
+	// unknown and truncated code is shown as raw bytes
+	text = Disassemble([]byte{0xff, 0x21, 0x01})
+	assert.Contains(t, text, ".byte 0xff")
+	assert.Contains(t, text, "unknown opcode")
+	assert.Contains(t, text, ".byte 0x21 0x01")
+	assert.Contains(t, text, "truncated push2")
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{0x20, 0x03, 0x1b, 0x2a, 0x01, 'n', 0x0f})
+	f.Add([]byte{0x29, ';', 0x2a, 0x02, '"', '\\'})
+	f.Add([]byte{0x2a, 0x05, 0x01})
+	f.Add([]byte{0xff, 0x0a, 0x00})
+	f.Fuzz(func(t *testing.T, code []byte) {
+		again, err := Assemble(Disassemble(code))
+		if err != nil {
+			t.Fatalf("disassembly of %x does not assemble: %v", code, err)
+		}
+		if !bytes.Equal(code, again) {
+			t.Fatalf("round trip of %x gave %x", code, again)
+		}
+	})
+}
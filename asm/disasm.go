@@ -0,0 +1,121 @@
+package asm
+
+import (
+	"blockchain/core"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Instr is one decoded instruction
+type Instr struct {
+	Offset int
+	Op     byte
+	// Name is empty for unknown opcodes
+	Name string
+	// Imm holds the operand bytes, for pushbytes without the length byte
+	Imm []byte
+	// Truncated is set when the code ends inside the immediate
+	Truncated bool
+}
+
+// Decode splits code into instructions. Unknown opcodes and truncated
+// immediates are kept so the whole input is covered.
+func Decode(code []byte) []Instr {
+	instrs := []Instr{}
+	for ip := 0; ip < len(code); {
+		ins := Instr{Offset: ip, Op: code[ip]}
+		info, ok := core.LookupOpcode(code[ip])
+		next := ip + 1
+		if ok {
+			ins.Name = info.Name
+			next = ip + core.InstrSize(code, ip)
+			start := ip + 1
+			if info.Immediate < 0 {
+				start++
+			}
+			if next > len(code) {
+				ins.Truncated = true
+				next = len(code)
+			}
+			if start < next {
+				ins.Imm = code[start:next]
+			}
+		}
+		instrs = append(instrs, ins)
+		ip = next
+	}
+	return instrs
+}
+
+// Disassemble prints code as mnemonics that Assemble reads back into the same
+// bytes. Each line is annotated with its offset and raw bytes.
+func Disassemble(code []byte) string {
+	buf := &strings.Builder{}
+	for _, ins := range Decode(code) {
+		raw := code[ins.Offset : ins.Offset+ins.size(code)]
+		text, note := ins.mnemonic(raw)
+		comment := fmt.Sprintf("%04x: %x", ins.Offset, raw)
+		if note != "" {
+			comment += " " + note
+		}
+		fmt.Fprintf(buf, "%-32s ; %s\n", text, comment)
+	}
+	return buf.String()
+}
+
+func (ins Instr) size(code []byte) int {
+	if ins.Name == "" {
+		return 1
+	}
+	return min(core.InstrSize(code, ins.Offset), len(code)-ins.Offset)
+}
+
+// mnemonic returns the source text of the instruction and a note about it
+func (ins Instr) mnemonic(raw []byte) (string, string) {
+	switch {
+	case ins.Name == "":
+		return rawBytes(raw), "unknown opcode"
+	case ins.Truncated:
+		return rawBytes(raw), "truncated " + ins.Name
+	}
+	info, _ := core.LookupOpcode(ins.Op)
+	switch {
+	case info.Immediate < 0:
+		if printable(ins.Imm) {
+			return ins.Name + " " + strconv.Quote(string(ins.Imm)), ""
+		}
+		return fmt.Sprintf("%s 0x%x", ins.Name, ins.Imm), ""
+	case ins.Name == "dup" || ins.Name == "swap":
+		return fmt.Sprintf("%s %d", ins.Name, ins.Imm[0]), ""
+	case ins.Name == "pushbyte":
+		text := fmt.Sprintf("%s 0x%02x", ins.Name, ins.Imm[0])
+		if ins.Imm[0] < 0x80 && unicode.IsPrint(rune(ins.Imm[0])) {
+			return text, strconv.QuoteRune(rune(ins.Imm[0]))
+		}
+		return text, ""
+	case info.Immediate > 0:
+		v := new(big.Int).SetBytes(ins.Imm)
+		return fmt.Sprintf("%s 0x%x", ins.Name, ins.Imm), "= " + v.String()
+	}
+	return ins.Name, ""
+}
+
+func rawBytes(raw []byte) string {
+	parts := make([]string, len(raw))
+	for i, b := range raw {
+		parts[i] = fmt.Sprintf("0x%02x", b)
+	}
+	return ".byte " + strings.Join(parts, " ")
+}
+
+func printable(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 || !unicode.IsPrint(rune(c)) {
+			return false
+		}
+	}
+	return true
+}
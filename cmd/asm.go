@@ -0,0 +1,87 @@
+package main
+
+import (
+	"blockchain/asm"
+	"blockchain/lang"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const toolUsage = `usage:
+  blockchain asm <file|->         assemble mnemonics, prints the bytecode as hex
+  blockchain disasm <hex|file|-> disassemble hex encoded bytecode
+  blockchain compile <file|->     compile a contract, prints the bytecode as hex
+  blockchain compile -S <file|->  print the generated assembly instead
+  blockchain trace <dir> <tx>     replay an included tx of the chain in dir, prints a JSON trace
+  blockchain debug <dir> <tx>     replay an included tx in a step debugger`
+
+// runTool runs one of the bytecode subcommands
+func runTool(args []string) error {
+	if len(args) == 3 && args[0] == "compile" && args[1] == "-S" {
+		src, err := readInput(args[2])
+		if err != nil {
+			return err
+		}
+		text, err := lang.CompileAsm(string(src))
+		if err != nil {
+			return err
+		}
+		fmt.Print(text)
+		return nil
+	}
+	if len(args) == 3 && (args[0] == "trace" || args[0] == "debug") {
+		return replayTx(args[0], args[1], args[2])
+	}
+	if len(args) != 2 {
+		return errors.New(toolUsage)
+	}
+	switch args[0] {
+	case "asm":
+		src, err := readInput(args[1])
+		if err != nil {
+			return err
+		}
+		code, err := asm.Assemble(string(src))
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(code))
+	case "disasm":
+		input := []byte(args[1])
+		if _, err := os.Stat(args[1]); err == nil || args[1] == "-" {
+			if input, err = readInput(args[1]); err != nil {
+				return err
+			}
+		}
+		code, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(input)), "0x"))
+		if err != nil {
+			return fmt.Errorf("bytecode is not hex: %w", err)
+		}
+		fmt.Print(asm.Disassemble(code))
+	case "compile":
+		src, err := readInput(args[1])
+		if err != nil {
+			return err
+		}
+		code, err := lang.Compile(string(src))
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(code))
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], toolUsage)
+	}
+	return nil
+}
+
+// readInput reads a file, "-" is stdin
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
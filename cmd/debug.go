@@ -0,0 +1,167 @@
+package main
+
+import (
+	"blockchain/core"
+	"blockchain/types"
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+)
+
+const debugHelp = `commands:
+  s, step          run the next instruction
+  c, continue      run to the next breakpoint
+  b, break <ip>    stop before the instruction at ip, 0x for hex, at any depth
+  d, delete <ip>   remove a breakpoint
+  stack            print the stack
+  q, quit          run to the end without stopping`
+
+// replayTx replays the included transaction hash of the chain stored in dir,
+// "trace" prints a JSON trace and "debug" steps through it
+func replayTx(mode, dir, hash string) error {
+	raw, err := hex.DecodeString(strings.TrimPrefix(hash, "0x"))
+	if err != nil || len(raw) != 32 {
+		return fmt.Errorf("invalid tx hash %q", hash)
+	}
+	genesis := core.DefaultGenesis()
+	if *genesisPath != "" {
+		if genesis, err = core.LoadGenesis(*genesisPath); err != nil {
+			return err
+		}
+	}
+	// the node may still be running and writing to dir
+	store, err := core.NewFileStorage(core.FileStorageOpts{Dir: dir, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	bc, err := core.NewBlockChain(log.NewNopLogger(), store, genesis)
+	if err != nil {
+		return err
+	}
+
+	var tracer core.Tracer = core.NewJSONTracer(os.Stdout)
+	if mode == "debug" {
+		tracer = newDebugger(os.Stdin, os.Stdout)
+	}
+	receipt, err := bc.TraceTx(types.HashFromBytes(raw), tracer)
+	if err != nil {
+		return err
+	}
+	if mode == "debug" {
+		fmt.Printf("success %t gas used %d", receipt.Success, receipt.GasUsed)
+		if receipt.Err != "" {
+			fmt.Printf(" error %s", receipt.Err)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// debugger is a core.Tracer that stops before instructions and reads
+// commands, it starts stepping
+type debugger struct {
+	in          *bufio.Scanner
+	out         io.Writer
+	breakpoints map[int]bool
+	stepping    bool
+	// quit runs to the end, it is set at the end of the input too
+	quit bool
+}
+
+func newDebugger(in io.Reader, out io.Writer) *debugger {
+	fmt.Fprintln(out, debugHelp)
+	return &debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		breakpoints: make(map[int]bool),
+		stepping:    true,
+	}
+}
+
+func (d *debugger) CaptureStep(step *core.Step) {
+	if d.quit || !(d.stepping || d.breakpoints[step.IP]) {
+		return
+	}
+	fmt.Fprintf(d.out, "depth %d %04x: %s gas %d\n", step.Depth, step.IP, step.OpName, step.Gas)
+	d.printStack(step)
+	for {
+		fmt.Fprint(d.out, "> ")
+		if !d.in.Scan() {
+			d.quit = true
+			return
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "s", "step":
+			d.stepping = true
+			return
+		case "c", "continue":
+			d.stepping = false
+			return
+		case "q", "quit":
+			d.quit = true
+			return
+		case "stack":
+			d.printStack(step)
+		case "b", "break", "d", "delete":
+			if len(fields) != 2 {
+				fmt.Fprintln(d.out, "missing ip")
+				continue
+			}
+			ip, err := strconv.ParseInt(fields[1], 0, 64)
+			if err != nil {
+				fmt.Fprintf(d.out, "invalid ip %q\n", fields[1])
+				continue
+			}
+			if fields[0][0] == 'b' {
+				d.breakpoints[int(ip)] = true
+			} else {
+				delete(d.breakpoints, int(ip))
+			}
+		default:
+			fmt.Fprintln(d.out, debugHelp)
+		}
+	}
+}
+
+func (d *debugger) CaptureStepEnd(step *core.Step) {
+	if d.quit || !d.stepping {
+		return
+	}
+	for _, r := range step.Reads {
+		fmt.Fprintf(d.out, "  read %s = %s\n", r.Key, r.Value)
+	}
+	for _, w := range step.Writes {
+		fmt.Fprintf(d.out, "  write %s = %s\n", w.Key, w.Value)
+	}
+	if step.Err != "" {
+		fmt.Fprintf(d.out, "  error %s\n", step.Err)
+	}
+}
+
+func (d *debugger) CaptureEnd(depth int, gasUsed uint64, ret []byte, err error) {
+	if d.quit {
+		return
+	}
+	fmt.Fprintf(d.out, "depth %d stopped, gas used %d return 0x%x\n", depth, gasUsed, ret)
+	if err != nil {
+		fmt.Fprintf(d.out, "  error %s\n", err)
+	}
+}
+
+// printStack prints the stack top first
+func (d *debugger) printStack(step *core.Step) {
+	for i := len(step.Stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "  %2d: %s\n", len(step.Stack)-1-i, step.Stack[i])
+	}
+}
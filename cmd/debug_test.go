@@ -0,0 +1,57 @@
+package main
+
+import (
+	"blockchain/core"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runSteps feeds the debugger one step per ip and a stop at the end
+func runSteps(d *debugger, ips ...int) {
+	for _, ip := range ips {
+		step := &core.Step{IP: ip, OpName: "push", Gas: 100, Stack: []string{"1", "2"}}
+		d.CaptureStep(step)
+		d.CaptureStepEnd(step)
+	}
+	d.CaptureEnd(0, 10, nil, nil)
+}
+
+func TestDebugger(t *testing.T) {
+	out := &bytes.Buffer{}
+	// step once, set a breakpoint at 4, continue to it, print the stack and
+	// continue to the end
+	d := newDebugger(strings.NewReader("s\nb 0x4\nc\nstack\nc\n"), out)
+	runSteps(d, 0, 2, 4, 6)
+
+	text := out.String()
+	assert.Contains(t, text, "depth 0 0000: push gas 100")
+	assert.Contains(t, text, "depth 0 0002: push gas 100")
+	assert.Contains(t, text, "depth 0 0004: push gas 100")
+	assert.NotContains(t, text, "0006:")
+	// the stack is printed top first, on every stop and for the command
+	assert.Equal(t, 4, strings.Count(text, "   0: 2\n"))
+	assert.Contains(t, text, "depth 0 stopped, gas used 10")
+}
+
+func TestDebuggerDeleteAndQuit(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := newDebugger(strings.NewReader("b 2\nb 4\nd 2\nbreak\nc\nq\n"), out)
+	runSteps(d, 0, 2, 4, 6)
+
+	text := out.String()
+	assert.Contains(t, text, "missing ip")
+	assert.NotContains(t, text, "0002:")
+	assert.Contains(t, text, "0004:")
+	// quit runs to the end without printing
+	assert.NotContains(t, text, "0006:")
+	assert.NotContains(t, text, "stopped")
+
+	// the end of the input quits as well
+	out.Reset()
+	d = newDebugger(strings.NewReader(""), out)
+	runSteps(d, 0, 2)
+	assert.NotContains(t, out.String(), "0002:")
+}
@@ -5,23 +5,50 @@ import (
 	"blockchain/crypto"
 	"blockchain/network"
 	"bytes"
+	"flag"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
+var (
+	genesisPath = flag.String("genesis", "", "genesis specification file (.json or .yaml), the default chain is used if empty")
+	dataDir     = flag.String("datadir", "", "directory the nodes keep their chains in, one subdirectory per node, chains are kept in memory if empty")
+)
+
 func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		if err := runTool(flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	genesis := core.DefaultGenesis()
+	if *genesisPath != "" {
+		g, err := core.LoadGenesis(*genesisPath)
+		if err != nil {
+			panic(err)
+		}
+		genesis = g
+	}
+
 	pri := crypto.GenerateKeyPair()
-	server := makeServer(&pri, ":30008", []string{":30009", ":30010"})
+	server := makeServer(&pri, ":30008", []string{":30009", ":30010"}, genesis)
 	// ! nil represent non validtor
-	remoteA := makeServer(nil, ":30009", []string{":30008", ":30010"})
-	remoteB := makeServer(nil, ":30010", []string{":30008"})
+	remoteA := makeServer(nil, ":30009", []string{":30008", ":30010"}, genesis)
+	remoteB := makeServer(nil, ":30010", []string{":30008"}, genesis)
 	go server.Start()
 	go remoteA.Start()
 	go remoteB.Start()
 	go dialTest()
 	go func() {
 		time.Sleep(11 * time.Second)
-		lateNode := makeServer(nil, ":6000", []string{":30008"})
+		lateNode := makeServer(nil, ":6000", []string{":30008"}, genesis)
 		go lateNode.Start()
 	}()
 
@@ -29,13 +56,21 @@ func main() {
 	select {}
 }
 
-func makeServer(pri *crypto.PrivateKey, addr string, seeds []string) *network.Server {
+func makeServer(pri *crypto.PrivateKey, addr string, seeds []string, genesis *core.Genesis) *network.Server {
 	opts := network.ServerOpts{
 		ListenAddress: addr,
 		PrivateKey:    pri,
 		NodeSeeds:     seeds,
+		Genesis:       genesis,
+	}
+	if *dataDir != "" {
+		// the nodes of this process must not share a storage
+		opts.DataDir = filepath.Join(*dataDir, strings.TrimPrefix(addr, ":"))
+	}
+	s, err := network.NewServer(opts)
+	if err != nil {
+		panic(err)
 	}
-	s := network.NewServer(opts)
 
 	return s
 }
@@ -49,20 +84,29 @@ func dialTest() error {
 		return err
 	}
 	pri := crypto.GenerateKeyPair()
-	contract := []byte{0x01, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
-
-	tx := core.NewTransaction(contract)
-	tx.Sign(&pri)
-	buf := &bytes.Buffer{}
-	// use proto
-	if err := core.NewTxEncoder(buf).Encode(tx); err != nil {
+	contract, err := core.DecodeLegacy([]byte{0x01, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f})
+	if err != nil {
 		return err
 	}
-	msg := network.NewMessage(network.MessageTx, buf.Bytes())
 
-	if err != nil {
-		return err
+	// the first transaction of a fresh account deploys the contract, the
+	// second one runs it
+	deploy := core.NewTransaction(contract)
+	deploy.Nonce = 0
+	deploy.GasLimit = 10_000
+	call := core.NewTransaction(nil)
+	call.To = core.ContractAddress(pri.PublicKey(), 0)
+	call.Nonce = 1
+	call.GasLimit = 10_000
+	for _, tx := range []*core.Transaction{deploy, call} {
+		tx.Sign(&pri)
+		buf := &bytes.Buffer{}
+		// use proto
+		if err := core.NewTxEncoder(buf).Encode(tx); err != nil {
+			return err
+		}
+		msg := network.NewMessage(network.MessageTx, buf.Bytes())
+		conn.Write([]byte(msg.Bytes()))
 	}
-	conn.Write([]byte(msg.Bytes()))
 	return nil
 }
@@ -5,7 +5,6 @@ import (
 	"blockchain/idl/pb"
 	"blockchain/types"
 	"bytes"
-	"crypto/sha256"
 	"encoding/gob"
 	"fmt"
 	"time"
@@ -18,6 +17,10 @@ type Header struct {
 	TimeStamp int64
 	Nonce     uint32
 	Height    uint32
+	// StateRoot commits to the state after executing the block
+	StateRoot types.Hash
+	// LogsBloom holds the addresses and topics of the logs of the block
+	LogsBloom Bloom
 }
 
 type Block struct {
@@ -67,7 +70,7 @@ func (b *Block) Verify() error {
 		return err
 	}
 	if dataHash != b.DataHash {
-		fmt.Errorf("block (%s) has invalid datahash", b.hash)
+		return fmt.Errorf("block (%s) has invalid datahash", b.hash)
 	}
 	return nil
 
@@ -103,6 +106,8 @@ func (b *Block) ToProto() *pb.Block {
 			Timestamp: b.TimeStamp,
 			Nonce:     b.Nonce,
 			Height:    b.Height,
+			StateRoot: b.StateRoot[:],
+			LogsBloom: b.LogsBloom[:],
 		},
 		Validator: &pb.PublicKey{
 			Key: b.Validator,
@@ -120,6 +125,8 @@ func (b *Block) FromProto(proto *pb.Block) {
 	b.TimeStamp = proto.Header.Timestamp
 	b.Nonce = proto.Header.Nonce
 	b.Height = proto.Header.Height
+	copy(b.StateRoot[:], proto.Header.StateRoot)
+	copy(b.LogsBloom[:], proto.Header.LogsBloom)
 	b.Validator = proto.Validator.Key
 	b.Signature = crypto.FromProto(proto.Signature)
 	b.hash = types.Hash(proto.Hash)
@@ -139,15 +146,35 @@ func (b *Block) Decode(dec Decoder[*Block]) error {
 	return dec.Decode(b)
 }
 
+// CalculateDatahash returns the merkle root over the transaction hashes
 func CalculateDatahash(txx []*Transaction) (types.Hash, error) {
-	buf := &bytes.Buffer{}
-	for _, tx := range txx {
-		if err := NewTxEncoder(buf).Encode(tx); err != nil {
-			return types.Hash{}, err
+	return MerkleRoot(txHashes(txx)), nil
+}
+
+func txHashes(txx []*Transaction) []types.Hash {
+	hashes := make([]types.Hash, len(txx))
+	for i, tx := range txx {
+		hashes[i] = tx.Hash(TxHasher{})
+	}
+	return hashes
+}
+
+// TxProof returns the proof that the transaction with hash is part of the
+// block data hash
+func (b *Block) TxProof(hash types.Hash) (*MerkleProof, error) {
+	hashes := txHashes(b.Transaction)
+	for i, h := range hashes {
+		if h == hash {
+			return NewMerkleProof(hashes, i)
 		}
 	}
-	hash := sha256.Sum256(buf.Bytes())
-	return hash, nil
+	return nil, fmt.Errorf("tx %s is not in block %d", hash, b.Height)
+}
+
+// VerifyTxProof checks a transaction inclusion proof against a header, it only
+// needs the header so light clients can use it
+func VerifyTxProof(h *Header, hash types.Hash, proof *MerkleProof) bool {
+	return proof.Verify(h.DataHash, hash)
 }
 
 func NewBLockFromHeader(h *Header, txx []*Transaction) (*Block, error) {
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"blockchain/crypto"
 	"blockchain/pkg/e"
+	"blockchain/types"
 	"fmt"
 	"sync"
 
@@ -14,20 +16,86 @@ type Blockchain struct {
 	Headers       []*Header
 	Block         []*Block
 	Validator     Validator
+	Genesis       *Genesis
 	Logger        log.Logger
 	ContractState *contractState
+	AccountState  *accountState
+	Receipts      *ReceiptStore
+	// checkpoints are copies of the state after every
+	// stateCheckpointInterval-th block, see stateAt
+	checkpoints map[uint32]*execution
 }
 
-func NewBlockChain(log log.Logger, genesis *Block) *Blockchain {
+// stateCheckpointInterval is the distance in blocks between the states kept
+// for lookups below the head
+const stateCheckpointInterval = 64
+
+// NewBlockChain opens a chain on top of store. An empty store is initialized
+// with the block derived from genesis, otherwise the stored blocks are
+// reloaded and re-executed on top of the genesis state.
+func NewBlockChain(log log.Logger, store Storage, genesis *Genesis) (*Blockchain, error) {
+	if err := genesis.Validate(); err != nil {
+		return nil, err
+	}
+	contracts, accounts, err := genesis.state()
+	if err != nil {
+		return nil, err
+	}
+	genesisBlock, err := genesis.Block()
+	if err != nil {
+		return nil, err
+	}
 	bc := &Blockchain{
 		Headers:       []*Header{},
-		Store:         NewStorage(),
+		Store:         store,
+		Genesis:       genesis,
 		Logger:        log,
-		ContractState: NewContractState(),
+		ContractState: contracts,
+		AccountState:  accounts,
+		Receipts:      NewReceiptStore(),
+		checkpoints:   make(map[uint32]*execution),
 	}
 	bc.Validator = NewBlockValidator(bc)
-	bc.AddBlockWithoutValidate(genesis)
-	return bc
+	if store.Len() == 0 {
+		if err := bc.AddBlockWithoutValidate(genesisBlock); err != nil {
+			return nil, err
+		}
+		return bc, nil
+	}
+	if err := bc.load(genesisBlock); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// load rebuilds the in-memory chain from the store
+func (bc *Blockchain) load(genesis *Block) error {
+	hasher := NewBlockHasher()
+	for height := uint32(0); height < bc.Store.Len(); height++ {
+		b, err := bc.Store.Get(height)
+		if err != nil {
+			return err
+		}
+		ex := bc.currentState()
+		if height == 0 {
+			if hasher.Hash(b.Header) != hasher.Hash(genesis.Header) {
+				return fmt.Errorf("stored genesis %s does not match %s", hasher.Hash(b.Header), hasher.Hash(genesis.Header))
+			}
+		} else {
+			if ex, err = bc.executeBlock(bc.currentState(), b); err != nil {
+				return fmt.Errorf("replay block %d failed: %w", height, err)
+			}
+			if root := ex.stateRoot(); root != b.StateRoot {
+				return fmt.Errorf("replay block %d reached state root %s, stored %s", height, root, b.StateRoot)
+			}
+			if ex.bloom() != b.LogsBloom {
+				return fmt.Errorf("replay block %d logs bloom does not match", height)
+			}
+		}
+		bc.commit(b, ex)
+	}
+	bc.Logger.Log("msg", "blockchain loaded from storage", "height", bc.Height())
+	return nil
 }
 
 func (bc *Blockchain) SetValidator(v Validator) {
@@ -38,16 +106,224 @@ func (bc *Blockchain) AddBlock(b *Block) error {
 	if err := bc.Validator.ValidateBlock(b); err != nil {
 		return fmt.Errorf("block validation failed: %v", err)
 	}
-	// VM
-	for _, tx := range b.Transaction {
-		vm := NewVM(tx.Data, bc.ContractState)
-		if err := vm.run(); err != nil {
-			bc.Logger.Log("execute tx instructions err", err, "hash", tx.hash)
+	ex, err := bc.executeBlock(bc.currentState(), b)
+	if err != nil {
+		return fmt.Errorf("block execution failed: %w", err)
+	}
+	// a rejected block is rolled back by dropping its execution
+	if err := bc.Validator.ValidateState(b, ex.stateRoot(), ex.bloom()); err != nil {
+		return fmt.Errorf("block validation failed: %w", err)
+	}
+	return bc.storeBlock(b, ex)
+}
+
+// execution is the state a block leads to, it only becomes the chain state
+// once the block is committed
+type execution struct {
+	contractState *contractState
+	accountState  *accountState
+	receipts      []*Receipt
+}
+
+func (ex *execution) stateRoot() types.Hash {
+	return stateRoot(ex.contractState, ex.accountState)
+}
+
+func (ex *execution) bloom() Bloom {
+	return logsBloom(ex.receipts)
+}
+
+func (bc *Blockchain) currentState() *execution {
+	return &execution{
+		contractState: bc.ContractState,
+		accountState:  bc.AccountState,
+	}
+}
+
+// executeBlock runs every transaction with its transfer in a snapshot of the
+// parent state, it returns the resulting state and one receipt per
+// transaction. A transaction with a wrong nonce or a transfer the sender can
+// not pay for rejects the whole block. The parent state is only changed
+// once the returned execution is committed, dropping it rolls the block back.
+func (bc *Blockchain) executeBlock(parent *execution, b *Block) (*execution, error) {
+	accounts := parent.accountState.snapshot()
+	contracts := parent.contractState.snapshot()
+	blockHash := NewBlockHasher().Hash(b.Header)
+	receipts := make([]*Receipt, 0, len(b.Transaction))
+	logIndex := 0
+	for i, tx := range b.Transaction {
+		if err := accounts.useNonce(tx); err != nil {
+			return nil, fmt.Errorf("tx %s: %w", tx.Hash(TxHasher{}), err)
+		}
+		receipt := &Receipt{
+			TxHash:    tx.Hash(TxHasher{}),
+			BlockHash: blockHash,
+			Height:    b.Height,
+			Index:     i,
+			Success:   true,
+		}
+		// every tx runs in its own snapshot, a failed tx leaves no writes and no
+		// transfer behind, only its nonce is used up
+		txState, txAccounts := contracts.snapshot(), accounts.snapshot()
+		if err := txAccounts.move(tx.From, tx.receiver(), tx.Value); err != nil {
+			return nil, fmt.Errorf("tx %s: %w", tx.Hash(TxHasher{}), err)
 		}
-		bc.Logger.Log("msg", "contract been exec", "data", fmt.Sprintf("%v", bc.ContractState.data))
+		if err := applyTx(txState, txAccounts, newBlockContext(b), tx, receipt, nil); err != nil {
+			receipt.Success = false
+			receipt.Err = err.Error()
+			receipt.Writes = nil
+			receipt.ContractAddress = nil
+			receipt.Logs = nil
+			receipt.ReturnValue = nil
+		} else {
+			txState.commit()
+			txAccounts.commit()
+			for _, l := range receipt.Logs {
+				l.TxHash, l.Height, l.TxIndex, l.Index = receipt.TxHash, b.Height, i, logIndex
+				logIndex++
+			}
+		}
+		receipts = append(receipts, receipt)
+	}
+	return &execution{
+		contractState: contracts,
+		accountState:  accounts,
+		receipts:      receipts,
+	}, nil
+}
+
+// commit merges the execution into the state it was executed on
+func (ex *execution) commit() {
+	ex.contractState.commit()
+	ex.accountState.commit()
+}
+
+// copy returns a flat copy of the state that later commits do not change
+func (ex *execution) copy() *execution {
+	contracts := NewContractState()
+	for key, value := range ex.contractState.entries() {
+		contracts.data[key] = value
+	}
+	accounts := NewAccountState(nil)
+	for key, acc := range ex.accountState.entries() {
+		copied := *acc
+		accounts.accounts[key] = &copied
 	}
+	return &execution{contractState: contracts, accountState: accounts}
+}
 
-	return bc.AddBlockWithoutValidate(b)
+// CalculateHeader executes b on top of the chain without changing it and sets
+// the state root and logs bloom the block has to carry. Contracts can read the
+// validator, so b.Validator has to be set to the key that signs the block.
+func (bc *Blockchain) CalculateHeader(b *Block) error {
+	ex, err := bc.executeBlock(bc.currentState(), b)
+	if err != nil {
+		return err
+	}
+	b.StateRoot = ex.stateRoot()
+	b.LogsBloom = ex.bloom()
+	return nil
+}
+
+// StateRoot returns the state root of the chain head
+func (bc *Blockchain) StateRoot() types.Hash {
+	return stateRoot(bc.ContractState, bc.AccountState)
+}
+
+// stateAt returns the state after the block at height. Besides the head state
+// a copy is kept every stateCheckpointInterval blocks, older states are
+// rebuilt by replaying the blocks after the nearest checkpoint below height,
+// at most stateCheckpointInterval-1 of them.
+func (bc *Blockchain) stateAt(height uint32) (*execution, error) {
+	if height > bc.Height() {
+		return nil, e.ErrBlockUnKnown
+	}
+	if height == bc.Height() {
+		return bc.currentState(), nil
+	}
+	from := height - height%stateCheckpointInterval
+	var state *execution
+	if checkpoint, ok := bc.checkpoints[from]; ok {
+		// replay on a layer, the checkpoint itself stays as it is
+		state = &execution{
+			contractState: checkpoint.contractState.snapshot(),
+			accountState:  checkpoint.accountState.snapshot(),
+		}
+	} else {
+		contracts, accounts, err := bc.Genesis.state()
+		if err != nil {
+			return nil, err
+		}
+		state = &execution{contractState: contracts, accountState: accounts}
+		from = 0
+	}
+	for h := from + 1; h <= height; h++ {
+		ex, err := bc.executeBlock(state, bc.Block[h])
+		if err != nil {
+			return nil, fmt.Errorf("replay block %d failed: %w", h, err)
+		}
+		ex.commit()
+	}
+	return state, nil
+}
+
+// GetStateProof returns the contract state value of key after the block at
+// height, together with the proof against that block's state root. The
+// storage of a contract is keyed by ContractStorageKey. A height below the
+// head replays the blocks after the nearest state checkpoint, see stateAt.
+func (bc *Blockchain) GetStateProof(height uint32, key string) ([]byte, *MerkleProof, error) {
+	ex, err := bc.stateAt(height)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stateProof(ex.contractState, ex.accountState, contractKeyPrefix+key)
+}
+
+// GetCode returns the code of the contract at addr
+func (bc *Blockchain) GetCode(addr crypto.PublicKey) ([]byte, error) {
+	code, ok := bc.ContractState.code(addr)
+	if !ok {
+		return nil, e.ErrKeyUnKnown
+	}
+	return code, nil
+}
+
+// FilterTransactions splits txx into the transactions that apply cleanly to
+// the current account state and the ones that may apply later, like a future
+// nonce or a transfer the sender can not pay for yet. A block built from valid
+// will not be rejected for a wrong nonce or an unpaid transfer. Transactions
+// with a used nonce or above the block gas limit can never apply and are
+// dropped.
+func (bc *Blockchain) FilterTransactions(txx []*Transaction) (valid, later []*Transaction) {
+	accounts := bc.AccountState.snapshot()
+	limit := bc.Genesis.BlockGasLimit()
+	// a tx can come before the one whose nonce it follows, the rest is retried
+	// as long as a pass applies something
+	pending := txx
+	for {
+		applied := false
+		later = nil
+		for _, tx := range pending {
+			if tx.GasLimit > limit {
+				bc.Logger.Log("msg", "drop transaction", "hash", tx.Hash(TxHasher{}), "err", fmt.Errorf("gas limit %d above block gas limit %d", tx.GasLimit, limit))
+				continue
+			}
+			if nonce := accounts.Nonce(tx.From); tx.Nonce < nonce {
+				bc.Logger.Log("msg", "drop transaction", "hash", tx.Hash(TxHasher{}), "err", fmt.Errorf("%w: got %d, expected %d", e.ErrInvalidNonce, tx.Nonce, nonce))
+				continue
+			}
+			if err := accounts.transfer(tx); err != nil {
+				later = append(later, tx)
+				continue
+			}
+			valid = append(valid, tx)
+			applied = true
+		}
+		if !applied {
+			return valid, later
+		}
+		pending = later
+	}
 }
 
 func (bc *Blockchain) Height() uint32 {
@@ -55,14 +331,49 @@ func (bc *Blockchain) Height() uint32 {
 	return uint32(len(bc.Headers) - 1)
 }
 
+// AddBlockWithoutValidate executes and stores b without checking it against
+// the chain, it is used for genesis
 func (bc *Blockchain) AddBlockWithoutValidate(b *Block) error {
-	bc.Headers = append(bc.Headers, b.Header)
-	bc.Block = append(bc.Block, b)
-	// logger should here
-	bc.Logger.Log("msg", "new block created", "hash", NewBlockHasher().Hash(b.Header), "height", b.Height, "blockchain height", bc.Height())
+	ex := bc.currentState()
+	// genesis has nothing to execute
+	if len(bc.Headers) > 0 {
+		var err error
+		if ex, err = bc.executeBlock(bc.currentState(), b); err != nil {
+			return fmt.Errorf("block execution failed: %w", err)
+		}
+	}
+	return bc.storeBlock(b, ex)
+}
 
+// storeBlock writes b through to the store, a block only joins the chain once
+// it is stored
+func (bc *Blockchain) storeBlock(b *Block, ex *execution) error {
+	if err := bc.Store.Put(b); err != nil {
+		return err
+	}
+	bc.commit(b, ex)
+	// executeBlock also replays old blocks and checks proposals, only the
+	// receipts of blocks that join the chain are logged
+	for _, receipt := range ex.receipts {
+		if receipt.Success {
+			bc.Logger.Log("msg", "contract been exec", "hash", receipt.TxHash, "writes", fmt.Sprintf("%v", receipt.Writes))
+		} else {
+			bc.Logger.Log("execute tx instructions err", receipt.Err, "hash", receipt.TxHash)
+		}
+	}
+	bc.Logger.Log("msg", "new block created", "hash", NewBlockHasher().Hash(b.Header), "height", b.Height, "blockchain height", bc.Height())
 	return nil
+}
 
+// commit makes b the chain head and merges its execution into the chain state
+func (bc *Blockchain) commit(b *Block, ex *execution) {
+	ex.commit()
+	if b.Height > 0 && b.Height%stateCheckpointInterval == 0 {
+		bc.checkpoints[b.Height] = bc.currentState().copy()
+	}
+	bc.Headers = append(bc.Headers, b.Header)
+	bc.Block = append(bc.Block, b)
+	bc.Receipts.Put(b.Height, ex.receipts)
 }
 
 func (bc *Blockchain) HasBlock(b *Block) bool {
@@ -83,3 +394,35 @@ func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
 	}
 	return bc.Block[height], nil
 }
+
+func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
+	return bc.Store.GetByHash(hash)
+}
+
+// GetTransaction returns an included transaction and its position in the chain
+func (bc *Blockchain) GetTransaction(hash types.Hash) (*Transaction, *TxLocation, error) {
+	return bc.Store.GetTx(hash)
+}
+
+// GetTxProof returns the header that includes the transaction together with
+// the inclusion proof against its data hash
+func (bc *Blockchain) GetTxProof(hash types.Hash) (*Header, *MerkleProof, error) {
+	_, loc, err := bc.Store.GetTx(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := bc.GetBlock(loc.Height)
+	if err != nil {
+		return nil, nil, err
+	}
+	proof, err := b.TxProof(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.Header, proof, nil
+}
+
+// GetReceipt returns the execution receipt of an included transaction
+func (bc *Blockchain) GetReceipt(hash types.Hash) (*Receipt, error) {
+	return bc.Receipts.Get(hash)
+}
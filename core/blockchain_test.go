@@ -1,7 +1,10 @@
 package core
 
 import (
+	"blockchain/crypto"
+	"blockchain/pkg/e"
 	"blockchain/types"
+	"fmt"
 	"os"
 	"testing"
 
@@ -10,8 +13,8 @@ import (
 )
 
 func TestBlockchain(t *testing.T) {
-	genesis, _ := RandomBlock(0)
-	bc := NewBlockChain(log.NewLogfmtLogger(os.Stderr), genesis)
+	bc, err := NewBlockChain(log.NewLogfmtLogger(os.Stderr), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
 
 	assert.NotNil(t, bc)
 	for i := 0; i < 1000; i++ {
@@ -29,8 +32,8 @@ func TestBlockchain(t *testing.T) {
 }
 
 func TestGetHeader(t *testing.T) {
-	genesis, _ := RandomBlock(0)
-	bc := NewBlockChain(log.NewLogfmtLogger(os.Stderr), genesis)
+	bc, err := NewBlockChain(log.NewLogfmtLogger(os.Stderr), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
 	assert.NotNil(t, bc)
 	for i := 0; i < 1000; i++ {
 		block, _ := RandomBlock(i + 1)
@@ -40,3 +43,232 @@ func TestGetHeader(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, header.Height, uint32(0))
 }
+
+// nextBlock builds a valid signed block with txx on top of the chain head
+func nextBlock(t *testing.T, bc *Blockchain, txx []*Transaction) *Block {
+	header, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	block, err := NewBLockFromHeader(header, txx)
+	assert.Nil(t, err)
+	pri := crypto.GenerateKeyPair()
+	block.Validator = pri.PublicKey()
+	// blocks that can not execute keep a zero root, AddBlock rejects them anyway
+	_ = bc.CalculateHeader(block)
+	assert.Nil(t, block.Sign(pri))
+	return block
+}
+
+// deployTx deploys code, the contract lives at
+// ContractAddress(pri.PublicKey(), nonce)
+func deployTx(t *testing.T, pri crypto.PrivateKey, code []byte, nonce, gasLimit uint64) *Transaction {
+	tx := NewTransaction(code)
+	tx.Nonce = nonce
+	tx.GasLimit = gasLimit
+	assert.Nil(t, tx.Sign(&pri))
+	return tx
+}
+
+// callTx runs the contract at to
+func callTx(t *testing.T, pri crypto.PrivateKey, to crypto.PublicKey, nonce, gasLimit uint64) *Transaction {
+	tx := NewTransaction(nil)
+	tx.To = to
+	tx.Nonce = nonce
+	tx.GasLimit = gasLimit
+	assert.Nil(t, tx.Sign(&pri))
+	return tx
+}
+
+func TestBlockTimestamp(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, nil)))
+	parent, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+
+	// a block can not go back to or stay at the time of its parent
+	pri := crypto.GenerateKeyPair()
+	for _, timestamp := range []int64{parent.TimeStamp, parent.TimeStamp - 1} {
+		block := nextBlock(t, bc, nil)
+		block.TimeStamp = timestamp
+		assert.Nil(t, block.Sign(pri))
+		assert.ErrorContains(t, bc.AddBlock(block), "invalid block timestamp")
+	}
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestReceipts(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	// 1 [O O F] 3 pack store
+	deployStore := deployTx(t, pri, []byte{0x20, 0x01, 0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x0f}, 0, testGas)
+	// [F] 1 pack get, the key is missing
+	deployGet := deployTx(t, pri, []byte{0x29, 0x46, 0x20, 0x01, 0x0d, 0x10}, 1, testGas)
+	storeAddr := ContractAddress(pri.PublicKey(), 0)
+	store := callTx(t, pri, storeAddr, 2, testGas)
+	get := callTx(t, pri, ContractAddress(pri.PublicKey(), 1), 3, testGas)
+
+	block := nextBlock(t, bc, []*Transaction{deployStore, deployGet, store, get})
+	assert.Nil(t, bc.AddBlock(block))
+	blockHash := NewBlockHasher().Hash(block.Header)
+
+	receipt, err := bc.GetReceipt(deployStore.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success)
+	assert.Equal(t, storeAddr, receipt.ContractAddress)
+	assert.Equal(t, []string{ContractCodeKey(storeAddr)}, receipt.Writes)
+
+	receipt, err = bc.GetReceipt(store.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success)
+	assert.Equal(t, blockHash, receipt.BlockHash)
+	assert.Equal(t, uint32(1), receipt.Height)
+	assert.Equal(t, 2, receipt.Index)
+	assert.Equal(t, []string{ContractStorageKey(storeAddr, "OOF")}, receipt.Writes)
+	assert.Nil(t, receipt.ContractAddress)
+
+	receipt, err = bc.GetReceipt(get.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	assert.NotEmpty(t, receipt.Err)
+	assert.Equal(t, 3, receipt.Index)
+
+	tx, loc, err := bc.GetTransaction(get.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, get.To, tx.To)
+	assert.Equal(t, &TxLocation{Height: 1, Index: 3}, loc)
+
+	receipts, err := bc.Receipts.GetBlockReceipts(1)
+	assert.Nil(t, err)
+	assert.Len(t, receipts, 4)
+
+	_, err = bc.GetReceipt(types.Hash{})
+	assert.NotNil(t, err)
+}
+
+func TestAccountTransfer(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	bob := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	transfer := func(from crypto.PrivateKey, to crypto.PublicKey, value, nonce uint64) *Transaction {
+		tx := NewTransaction([]byte{0x20, 0x01})
+		tx.To = to
+		tx.Value = value
+		tx.Nonce = nonce
+		assert.Nil(t, tx.Sign(&from))
+		return tx
+	}
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		transfer(alice, bob.PublicKey(), 30, 0),
+		transfer(alice, bob.PublicKey(), 20, 1),
+	})))
+	assert.Equal(t, uint64(50), bc.AccountState.Balance(alice.PublicKey()))
+	assert.Equal(t, uint64(50), bc.AccountState.Balance(bob.PublicKey()))
+	assert.Equal(t, uint64(2), bc.AccountState.Nonce(alice.PublicKey()))
+
+	// replayed nonce
+	err = bc.AddBlock(nextBlock(t, bc, []*Transaction{transfer(alice, bob.PublicKey(), 1, 1)}))
+	assert.ErrorIs(t, err, e.ErrInvalidNonce)
+	// bob can not pay more than he has, the valid first tx is not applied either
+	err = bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		transfer(alice, bob.PublicKey(), 10, 2),
+		transfer(bob, alice.PublicKey(), 70, 0),
+	}))
+	assert.ErrorIs(t, err, e.ErrInsufficientBalance)
+	assert.Equal(t, uint64(50), bc.AccountState.Balance(alice.PublicKey()))
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// the proposer side leaves out what would be rejected, the tx with the
+	// used nonce is dropped and the future nonce waits
+	future := transfer(bob, alice.PublicKey(), 10, 5)
+	txx, later := bc.FilterTransactions([]*Transaction{
+		transfer(bob, alice.PublicKey(), 70, 0),
+		transfer(bob, alice.PublicKey(), 10, 0),
+		future,
+	})
+	assert.Len(t, txx, 1)
+	assert.Equal(t, []*Transaction{future}, later)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, txx)))
+	assert.Equal(t, uint64(60), bc.AccountState.Balance(alice.PublicKey()))
+	assert.Equal(t, uint64(40), bc.AccountState.Balance(bob.PublicKey()))
+}
+
+func TestStateRoot(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+	genesis, _ := bc.GetHeader(0)
+	assert.Equal(t, bc.StateRoot(), genesis.StateRoot)
+
+	pri := crypto.GenerateKeyPair()
+	addr := ContractAddress(pri.PublicKey(), 0)
+	txx := []*Transaction{
+		deployTx(t, pri, storeProgram, 0, testGas),
+		callTx(t, pri, addr, 1, testGas),
+	}
+
+	// a block claiming a different post state is rejected and changes nothing
+	block := nextBlock(t, bc, txx)
+	block.StateRoot = genesis.StateRoot
+	assert.Nil(t, block.Sign(pri))
+	assert.NotNil(t, bc.AddBlock(block))
+	assert.Equal(t, genesis.StateRoot, bc.StateRoot())
+	assert.Equal(t, uint64(0), bc.AccountState.Nonce(pri.PublicKey()))
+	_, err = bc.ContractState.get(ContractStorageKey(addr, "F"))
+	assert.NotNil(t, err)
+
+	block = nextBlock(t, bc, txx)
+	assert.Nil(t, bc.AddBlock(block))
+	assert.Equal(t, block.StateRoot, bc.StateRoot())
+	assert.NotEqual(t, genesis.StateRoot, bc.StateRoot())
+
+	// the same block executed on another node reaches the same root
+	other, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+	assert.Nil(t, other.AddBlock(block))
+	assert.Equal(t, bc.StateRoot(), other.StateRoot())
+}
+
+func TestStateAtCheckpoints(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	// stores its call data under n, without call data it returns n
+	code := []byte{0x37, 0x20, 0x09, 0x1a, 0x2a, 0x01, 'n', 0x10, 0x1d, 0x1b, 0x36, 0x2a, 0x01, 'n', 0x0f}
+	addr := ContractAddress(alice.PublicKey(), 0)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{deployTx(t, alice, code, 0, testGas)})))
+	blocks := 2*stateCheckpointInterval + 10
+	for nonce := uint64(1); nonce < uint64(blocks); nonce++ {
+		tx := callTx(t, alice, addr, nonce, testGas)
+		tx.CallData = []byte(fmt.Sprint(nonce))
+		assert.Nil(t, tx.Sign(&alice))
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{tx})))
+	}
+	assert.Equal(t, uint32(blocks), bc.Height())
+	assert.Len(t, bc.checkpoints, 2)
+
+	// every old state matches the root its block committed to, twice to see
+	// that replaying leaves the checkpoints alone
+	for i := 0; i < 2; i++ {
+		for h := uint32(0); h <= bc.Height(); h++ {
+			ex, err := bc.stateAt(h)
+			assert.Nil(t, err)
+			assert.Equal(t, bc.Headers[h].StateRoot, ex.stateRoot(), h)
+		}
+	}
+
+	read := NewTransaction(nil)
+	read.From = alice.PublicKey()
+	read.To = addr
+	for _, h := range []uint32{stateCheckpointInterval, stateCheckpointInterval + 1, 2*stateCheckpointInterval - 1} {
+		receipt, err := bc.CallAt(read, h)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprint(h-1)), receipt.ReturnValue)
+	}
+}
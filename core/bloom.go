@@ -0,0 +1,74 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// BloomSize is the size of a Bloom in bytes
+const BloomSize = 256
+
+// bloomHashes is the number of bits set per added value
+const bloomHashes = 3
+
+// Bloom is a bloom filter over the log addresses and topics of a block. A
+// value that was added always tests positive, other values only with a small
+// false positive rate, so a filter can skip every block whose bloom misses.
+type Bloom [BloomSize]byte
+
+// bloomBits returns the bit positions of value, taken from its sha256
+func bloomBits(value []byte) [bloomHashes]uint {
+	hash := sha256.Sum256(value)
+	var bits [bloomHashes]uint
+	for i := range bits {
+		bits[i] = uint(binary.BigEndian.Uint16(hash[2*i:])) % (8 * BloomSize)
+	}
+	return bits
+}
+
+func (b *Bloom) Add(value []byte) {
+	for _, bit := range bloomBits(value) {
+		b[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether value may have been added
+func (b *Bloom) Test(value []byte) bool {
+	for _, bit := range bloomBits(value) {
+		if b[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestAny reports whether any of values may have been added, it is true for
+// no values
+func (b *Bloom) TestAny(values [][]byte) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if b.Test(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Bloom) String() string {
+	return hex.EncodeToString(b[:])
+}
+
+// logsBloom adds the address and topic of every log in receipts
+func logsBloom(receipts []*Receipt) Bloom {
+	var bloom Bloom
+	for _, r := range receipts {
+		for _, l := range r.Logs {
+			bloom.Add(l.Address)
+			bloom.Add(l.Topic)
+		}
+	}
+	return bloom
+}
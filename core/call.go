@@ -0,0 +1,97 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"fmt"
+)
+
+// maxCallDepth bounds how deep calls nest, a call beyond it fails
+const maxCallDepth = 64
+
+// call runs the code at the callee address in a nested vm. It pops the
+// address, the gas the callee may use, the value sent to it and the argument
+// bytes, which become the call data of the callee. The callee gets at most
+// the gas left and sees the state of the caller. Afterwards the return value
+// of the callee, empty if it returned nothing, and 1 on success or 0 on
+// failure are pushed. A failed callee is rolled back together with the value transfer,
+// the caller decides whether to fail itself.
+func (vm *VM) call() error {
+	addr, err := vm.stack.popBytes()
+	if err != nil {
+		return err
+	}
+	gasWord, err := vm.stack.popWord()
+	if err != nil {
+		return err
+	}
+	valueWord, err := vm.stack.popWord()
+	if err != nil {
+		return err
+	}
+	args, err := vm.stack.popBytes()
+	if err != nil {
+		return err
+	}
+	if !valueWord.IsUint64() {
+		return fmt.Errorf("%w: call value %s", ErrBadOperand, valueWord)
+	}
+	gas := vm.gasLimit - vm.gasUsed
+	if gasWord.IsUint64() && gasWord.Uint64() < gas {
+		gas = gasWord.Uint64()
+	}
+
+	callee, err := vm.runCallee(crypto.PublicKey(addr), args, gas, valueWord.Uint64())
+	if callee != nil {
+		vm.steps += callee.steps
+		if err := vm.useGas(callee.gasUsed); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		if err := vm.stack.push([]byte{}); err != nil {
+			return err
+		}
+		return vm.stack.push(wordBool(false))
+	}
+	callee.contractstate.commit()
+	callee.accounts.commit()
+	vm.writes = append(vm.writes, callee.writes...)
+	vm.logs = append(vm.logs, callee.logs...)
+	ret := callee.ret
+	if ret == nil {
+		ret = []byte{}
+	}
+	if err := vm.stack.push(ret); err != nil {
+		return err
+	}
+	return vm.stack.push(wordBool(true))
+}
+
+// runCallee runs the code or the precompile at addr on snapshots of the caller
+// state, the returned vm is nil when the callee never ran
+func (vm *VM) runCallee(addr crypto.PublicKey, args []byte, gas, value uint64) (*VM, error) {
+	if vm.depth == maxCallDepth {
+		return nil, fmt.Errorf("call depth %d reached", maxCallDepth)
+	}
+	contracts, accounts := vm.contractstate.snapshot(), vm.accounts.snapshot()
+	if err := accounts.move(vm.address, addr, value); err != nil {
+		return nil, err
+	}
+	code, _ := contracts.code(addr)
+	callee := NewVM(code, contracts, gas)
+	callee.accounts = accounts
+	callee.address = addr
+	callee.namespace = ContractStorageKey(addr, "")
+	callee.depth = vm.depth + 1
+	callee.input = args
+	callee.caller = vm.address
+	callee.value = value
+	callee.block = vm.block
+	callee.tracer = vm.tracer
+	// the steps of the callee count against the caller
+	callee.stepLimit = vm.stepLimit - vm.steps
+	if p, ok := precompiles[addr.String()]; ok {
+		return callee, callee.runPrecompile(p)
+	}
+	return callee, callee.run()
+}
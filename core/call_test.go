@@ -0,0 +1,152 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"bytes"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// callCode calls addr with args, value and gas
+func callCode(addr crypto.PublicKey, args []byte, value byte, gas uint32) []byte {
+	code := []byte{instrPushBytes, byte(len(args))}
+	code = append(code, args...)
+	code = append(code, instrPush1, value, instrPush4, byte(gas>>24), byte(gas>>16), byte(gas>>8), byte(gas))
+	code = append(code, instrPushBytes, byte(len(addr)))
+	code = append(code, addr...)
+	return append(code, instrCall)
+}
+
+// the callee stores its call data under k and returns "ok"
+var calleeCode = []byte{0x36, 0x2a, 0x01, 'k', 0x0f, 0x2a, 0x02, 'o', 'k', 0x1d}
+
+func newCallVM(code []byte, callee []byte, gas uint64) (*VM, crypto.PublicKey, crypto.PublicKey) {
+	caller := crypto.PublicKey(bytes.Repeat([]byte{0xaa}, ContractAddressSize))
+	addr := crypto.PublicKey(bytes.Repeat([]byte{0xbb}, ContractAddressSize))
+	state := NewContractState()
+	state.put(ContractCodeKey(addr), callee)
+	vm := NewVM(code, state, gas)
+	vm.address = caller
+	vm.namespace = ContractStorageKey(caller, "")
+	vm.accounts = NewAccountState(GenesisAlloc{caller.String(): 10})
+	return vm, caller, addr
+}
+
+func TestVMCall(t *testing.T) {
+	vm, _, addr := newCallVM(nil, calleeCode, testGas)
+	vm.data = callCode(addr, []byte("args"), 0, 1000)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{[]byte("ok"), uint64(1)}, stackItems(vm))
+	value, err := vm.contractstate.get(ContractStorageKey(addr, "k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("args"), value)
+	assert.Equal(t, []string{ContractStorageKey(addr, "k")}, vm.writes)
+	// pushes, the call and the callee: calldata, 2 pushbytes, store of 4
+	// bytes, return
+	assert.Equal(t, 4+gasTable[instrCall]+2+4*gasCallDataByte+1+100+4*gasStoreByte+1, vm.gasUsed)
+
+	// a missing contract only receives the value
+	vm, caller, _ := newCallVM(nil, nil, testGas)
+	other := crypto.PublicKey(bytes.Repeat([]byte{0xcc}, ContractAddressSize))
+	vm.data = callCode(other, nil, 4, 1000)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{[]byte{}, uint64(1)}, stackItems(vm))
+	assert.Equal(t, uint64(6), vm.accounts.Balance(caller))
+	assert.Equal(t, uint64(4), vm.accounts.Balance(other))
+}
+
+func TestVMCallFailure(t *testing.T) {
+	// store and then divide by zero
+	failing := append(append([]byte{}, calleeCode[:5]...), 0x20, 0x00, 0x20, 0x01, 0x12)
+	vm, caller, addr := newCallVM(nil, failing, testGas)
+	vm.data = callCode(addr, []byte("args"), 3, 1000)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{[]byte{}, uint64(0)}, stackItems(vm))
+	_, err := vm.contractstate.get(ContractStorageKey(addr, "k"))
+	assert.NotNil(t, err)
+	assert.Empty(t, vm.writes)
+	// the value goes back to the caller
+	assert.Equal(t, uint64(10), vm.accounts.Balance(caller))
+	assert.Equal(t, uint64(0), vm.accounts.Balance(addr))
+
+	// the callee runs out of the gas it was given, the caller goes on
+	vm, _, addr = newCallVM(nil, calleeCode, testGas)
+	vm.data = append(callCode(addr, []byte("args"), 0, 5), 0x20, 0x07)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{[]byte{}, uint64(0), uint64(7)}, stackItems(vm))
+	assert.Equal(t, 4+gasTable[instrCall]+5+1, vm.gasUsed)
+
+	// the caller can not pay the value
+	vm, _, addr = newCallVM(nil, calleeCode, testGas)
+	vm.data = callCode(addr, []byte("args"), 11, 1000)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{[]byte{}, uint64(0)}, stackItems(vm))
+
+	// the callee never gets more gas than the caller has left, which leaves
+	// nothing for the caller
+	vm, _, addr = newCallVM(nil, calleeCode, 150)
+	vm.data = append(callCode(addr, []byte("args"), 0, 1<<30), 0x20, 0x07)
+	assert.ErrorIs(t, vm.run(), ErrOutOfGas)
+	assert.Equal(t, uint64(150), vm.gasUsed)
+	assert.Equal(t, []any{[]byte{}, uint64(0)}, stackItems(vm))
+
+	// the arguments must be bytes
+	vm, _, addr = newCallVM(nil, calleeCode, testGas)
+	vm.data = append([]byte{0x20, 0x01}, callCode(addr, nil, 0, 1000)[2:]...)
+	assert.ErrorIs(t, vm.run(), ErrBadOperand)
+}
+
+func TestVMCallDepth(t *testing.T) {
+	// calls itself and then stores the result of the call
+	self := crypto.PublicKey(bytes.Repeat([]byte{0xbb}, ContractAddressSize))
+	code := append(callCode(self, nil, 0, 1<<30), 0x27, 0x2a, 0x01, 'x', 0x0f, 0x1c)
+	vm, _, _ := newCallVM(code, code, testGas)
+	vm.address = self
+	vm.namespace = ContractStorageKey(self, "")
+	assert.Nil(t, vm.run())
+	// the top level and maxCallDepth nested calls, the call below them fails
+	assert.Len(t, vm.writes, maxCallDepth+1)
+	assert.Less(t, vm.steps, 20*(maxCallDepth+1))
+}
+
+func TestContractCall(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	callee := ContractAddress(alice.PublicKey(), 0)
+	caller := ContractAddress(alice.PublicKey(), 1)
+	// the caller forwards 2 of the value it got and stores the call result
+	callerCode := append(callCode(callee, []byte("hi"), 2, 1000), 0x2a, 0x02, 'o', 'k', 0x0f, 0x2a, 0x03, 'r', 'e', 't', 0x0f)
+	call := callTx(t, alice, caller, 2, testGas)
+	call.Value = 5
+	assert.Nil(t, call.Sign(&alice))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deployTx(t, alice, calleeCode, 0, testGas),
+		deployTx(t, alice, callerCode, 1, testGas),
+		call,
+	})))
+
+	receipt, err := bc.GetReceipt(call.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, []string{
+		ContractStorageKey(callee, "k"),
+		ContractStorageKey(caller, "ok"),
+		ContractStorageKey(caller, "ret"),
+	}, receipt.Writes)
+
+	value, err := bc.ContractState.get(ContractStorageKey(callee, "k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hi"), value)
+	value, err = bc.ContractState.get(ContractStorageKey(caller, "ret"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ok"), value)
+	assert.Equal(t, uint64(3), bc.AccountState.Balance(caller))
+	assert.Equal(t, uint64(2), bc.AccountState.Balance(callee))
+	assert.Equal(t, uint64(95), bc.AccountState.Balance(alice.PublicKey()))
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Contracts are accounts that hold code. A deploy transaction has no receiver
+// and carries the code in Data, the code is stored at an address derived from
+// the sender and the tx nonce, it has to pass VerifyCode. A transaction to a
+// contract runs its code, the store and get instructions only reach the
+// storage of that contract.
+//
+// Code and storage share the contract state, keyed by these prefixes.
+const (
+	codeKeyPrefix    = "code/"
+	storageKeyPrefix = "storage/"
+)
+
+// ContractAddressSize is the length of a contract address
+const ContractAddressSize = 20
+
+// gasDeploy is charged for storing code on top of gasStoreByte per byte
+const gasDeploy uint64 = 100
+
+// ContractAddress derives the address of the contract deployed by the tx of
+// from with nonce
+func ContractAddress(from crypto.PublicKey, nonce uint64) crypto.PublicKey {
+	buf := make([]byte, 0, len(from)+8)
+	buf = append(buf, from...)
+	buf = binary.BigEndian.AppendUint64(buf, nonce)
+	hash := sha256.Sum256(buf)
+	return crypto.PublicKey(hash[:ContractAddressSize])
+}
+
+// ContractCodeKey is the contract state key of the code at addr
+func ContractCodeKey(addr crypto.PublicKey) string {
+	return codeKeyPrefix + addr.String()
+}
+
+// ContractStorageKey is the contract state key under which the contract at
+// addr stores key
+func ContractStorageKey(addr crypto.PublicKey, key string) string {
+	return storageKeyPrefix + addr.String() + "/" + key
+}
+
+// code returns the code deployed at addr
+func (s *contractState) code(addr crypto.PublicKey) ([]byte, bool) {
+	return s.lookup(ContractCodeKey(addr))
+}
+
+// blockContext is the block a transaction runs in as the environment
+// instructions see it
+type blockContext struct {
+	height    uint32
+	timestamp int64
+	validator crypto.PublicKey
+}
+
+func newBlockContext(b *Block) blockContext {
+	return blockContext{height: b.Height, timestamp: b.TimeStamp, validator: b.Validator}
+}
+
+// applyTx runs the contract part of tx on state and accounts and fills in the
+// receipt. The caller commits them only when no error is returned. A tx to an
+// account without code is a plain transfer, its Data is not executed. tracer
+// may be nil.
+func applyTx(state *contractState, accounts *accountState, block blockContext, tx *Transaction, receipt *Receipt, tracer Tracer) error {
+	if tx.IsDeploy() {
+		addr := ContractAddress(tx.From, tx.Nonce)
+		if _, ok := state.code(addr); ok {
+			return fmt.Errorf("contract %s already exists", addr)
+		}
+		gas := gasDeploy + uint64(len(tx.Data))*gasStoreByte
+		if gas > tx.GasLimit {
+			receipt.GasUsed = tx.GasLimit
+			return ErrOutOfGas
+		}
+		receipt.GasUsed = gas
+		if err := VerifyCode(tx.Data); err != nil {
+			return err
+		}
+		key := ContractCodeKey(addr)
+		state.put(key, tx.Data)
+		receipt.Writes = []string{key}
+		receipt.ContractAddress = addr
+		return nil
+	}
+
+	code, ok := state.code(tx.To)
+	if len(tx.To) == 0 || !ok {
+		return nil
+	}
+	vm := NewVM(code, state, tx.GasLimit)
+	vm.accounts = accounts
+	vm.address = tx.To
+	vm.namespace = ContractStorageKey(tx.To, "")
+	vm.input = tx.CallData
+	vm.caller = tx.From
+	vm.value = tx.Value
+	vm.block = block
+	vm.tracer = tracer
+	err := vm.run()
+	receipt.GasUsed = vm.gasUsed
+	if err != nil {
+		return err
+	}
+	receipt.Writes = vm.writes
+	receipt.Logs = vm.logs
+	receipt.ReturnValue = vm.ret
+	return nil
+}
+
+// Call runs tx on the head state, see CallAt
+func (bc *Blockchain) Call(tx *Transaction) (*Receipt, error) {
+	return bc.CallAt(tx, bc.Height())
+}
+
+// CallAt runs tx as a dry run on the state after the block at height. The
+// state is a throwaway snapshot, the chain is never changed. The receipt
+// carries the return value, the logs, the writes and the gas used, a failed
+// execution is reported in it. The signature and the nonce of tx are not
+// checked, a zero GasLimit means the block gas limit. The environment
+// instructions see the block at height. A height below the head replays the
+// blocks after the nearest state checkpoint, see stateAt. An error is returned for an unknown height
+// or when the sender can not pay tx.Value.
+func (bc *Blockchain) CallAt(tx *Transaction, height uint32) (*Receipt, error) {
+	return bc.dryRun(tx, height, nil)
+}
+
+// RunCodeAt runs code as a dry run like CallAt, as if it was deployed at
+// tx.To, so it reads and writes the storage of tx.To. Without tx.To the code
+// runs at the address a deploy of tx would get.
+func (bc *Blockchain) RunCodeAt(code []byte, tx *Transaction, height uint32) (*Receipt, error) {
+	if code == nil {
+		code = []byte{}
+	}
+	return bc.dryRun(tx, height, code)
+}
+
+// dryRun executes tx on snapshots of the state after height, code replaces
+// the code of the receiver unless it is nil
+func (bc *Blockchain) dryRun(tx *Transaction, height uint32, code []byte) (*Receipt, error) {
+	state, err := bc.stateAt(height)
+	if err != nil {
+		return nil, err
+	}
+	block, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	msg := *tx
+	if msg.GasLimit == 0 {
+		msg.GasLimit = bc.Genesis.BlockGasLimit()
+	}
+	contracts, accounts := state.contractState.snapshot(), state.accountState.snapshot()
+	if code != nil {
+		if len(msg.To) == 0 {
+			msg.To = ContractAddress(msg.From, msg.Nonce)
+		}
+		contracts.put(ContractCodeKey(msg.To), code)
+	}
+	if err := accounts.move(msg.From, msg.receiver(), msg.Value); err != nil {
+		return nil, err
+	}
+	receipt := &Receipt{Success: true}
+	if err := applyTx(contracts, accounts, newBlockContext(block), &msg, receipt, nil); err != nil {
+		receipt.Success = false
+		receipt.Err = err.Error()
+	}
+	return receipt, nil
+}
@@ -0,0 +1,341 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"encoding/hex"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractStorageIsolation(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	bob := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	// two contracts with the same code write the same key
+	first := ContractAddress(alice.PublicKey(), 0)
+	second := ContractAddress(alice.PublicKey(), 1)
+	assert.NotEqual(t, first, second)
+	assert.Len(t, first, ContractAddressSize)
+	deploy := deployTx(t, alice, storeProgram, 0, testGas)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deploy,
+		deployTx(t, alice, storeProgram, 1, testGas),
+		callTx(t, alice, first, 2, testGas),
+	})))
+
+	code, err := bc.GetCode(first)
+	assert.Nil(t, err)
+	assert.Equal(t, storeProgram, code)
+	receipt, err := bc.GetReceipt(deploy.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, gasDeploy+uint64(len(storeProgram))*gasStoreByte, receipt.GasUsed)
+
+	value, err := bc.ContractState.get(ContractStorageKey(first, "F"))
+	assert.Nil(t, err)
+	assert.Equal(t, wordBytes(newWord(1)), value)
+	_, err = bc.ContractState.get(ContractStorageKey(second, "F"))
+	assert.NotNil(t, err)
+	// the vm can not reach raw keys either
+	_, err = bc.ContractState.get("F")
+	assert.NotNil(t, err)
+
+	// a tx to an account without code only transfers, its data is not run
+	transfer := NewTransaction(storeProgram)
+	transfer.To = bob.PublicKey()
+	transfer.Value = 10
+	transfer.Nonce = 3
+	transfer.GasLimit = testGas
+	assert.Nil(t, transfer.Sign(&alice))
+	// a deploy endows the new contract with its value
+	endowed := deployTx(t, alice, storeProgram, 4, testGas)
+	endowed.Value = 5
+	assert.Nil(t, endowed.Sign(&alice))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{transfer, endowed})))
+
+	receipt, err = bc.GetReceipt(transfer.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success)
+	assert.Empty(t, receipt.Writes)
+	assert.Equal(t, uint64(0), receipt.GasUsed)
+	assert.Equal(t, uint64(10), bc.AccountState.Balance(bob.PublicKey()))
+	assert.Equal(t, uint64(5), bc.AccountState.Balance(ContractAddress(alice.PublicKey(), 4)))
+	assert.Equal(t, uint64(85), bc.AccountState.Balance(alice.PublicKey()))
+}
+
+func TestDeployOutOfGas(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	addr := ContractAddress(pri.PublicKey(), 0)
+	deploy := deployTx(t, pri, storeProgram, 0, gasDeploy)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deploy,
+		// calling the missing contract is a no-op
+		callTx(t, pri, addr, 1, testGas),
+	})))
+
+	receipt, err := bc.GetReceipt(deploy.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	assert.Contains(t, receipt.Err, ErrOutOfGas.Error())
+	assert.Equal(t, gasDeploy, receipt.GasUsed)
+	assert.Nil(t, receipt.ContractAddress)
+	_, err = bc.GetCode(addr)
+	assert.NotNil(t, err)
+}
+
+func TestFailedTxRefundsValue(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	addr := ContractAddress(alice.PublicKey(), 0)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{deployTx(t, alice, storeProgram, 0, testGas)})))
+
+	// the call runs out of gas and the deploy fails verification, both keep
+	// their value with the sender
+	call := callTx(t, alice, addr, 1, 1)
+	call.Value = 30
+	assert.Nil(t, call.Sign(&alice))
+	deploy := deployTx(t, alice, []byte{0x20, 0x01, 0x19}, 2, testGas)
+	deploy.Value = 20
+	assert.Nil(t, deploy.Sign(&alice))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{call, deploy})))
+
+	for _, tx := range []*Transaction{call, deploy} {
+		receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.False(t, receipt.Success)
+	}
+	assert.Equal(t, uint64(100), bc.AccountState.Balance(alice.PublicKey()))
+	assert.Equal(t, uint64(0), bc.AccountState.Balance(addr))
+	assert.Equal(t, uint64(0), bc.AccountState.Balance(ContractAddress(alice.PublicKey(), 2)))
+	// the nonces are used up
+	assert.Equal(t, uint64(3), bc.AccountState.Nonce(alice.PublicKey()))
+}
+
+func TestGenesisContract(t *testing.T) {
+	addr := crypto.PublicKey(make([]byte, ContractAddressSize))
+	genesis := DefaultGenesis()
+	genesis.Storage = map[string]string{
+		// [F] 1 pack get return
+		ContractCodeKey(addr):         hex.EncodeToString([]byte{0x29, 0x46, 0x20, 0x01, 0x0d, 0x10, 0x1d}),
+		ContractStorageKey(addr, "F"): "2a",
+	}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	call := callTx(t, pri, addr, 0, testGas)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{call})))
+	receipt, err := bc.GetReceipt(call.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+}
+
+func TestContractReturnValue(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	// adds the first two words of the call data
+	adder := []byte{0x20, 0x00, 0x38, 0x20, 0x20, 0x38, 0x0b, 0x1d}
+	// stores the call data under k and returns 7
+	store := []byte{0x36, 0x2a, 0x01, 'k', 0x0f, 0x20, 0x07, 0x1d}
+	args := append(wordBytes(newWord(2)), wordBytes(newWord(40))...)
+	add := callTx(t, alice, ContractAddress(alice.PublicKey(), 0), 2, testGas)
+	add.CallData = args
+	assert.Nil(t, add.Sign(&alice))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deployTx(t, alice, adder, 0, testGas),
+		deployTx(t, alice, store, 1, testGas),
+		add,
+	})))
+
+	receipt, err := bc.GetReceipt(add.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, wordBytes(newWord(42)), receipt.ReturnValue)
+
+	// a read-only call returns the same without a transaction
+	msg := NewTransaction(nil)
+	msg.From = alice.PublicKey()
+	msg.To = ContractAddress(alice.PublicKey(), 0)
+	msg.CallData = args
+	receipt, err = bc.Call(msg)
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, wordBytes(newWord(42)), receipt.ReturnValue)
+
+	// its writes and value are dropped
+	storage := ContractAddress(alice.PublicKey(), 1)
+	msg = NewTransaction(nil)
+	msg.From = alice.PublicKey()
+	msg.To = storage
+	msg.Value = 10
+	msg.CallData = []byte("data")
+	receipt, err = bc.Call(msg)
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, wordBytes(newWord(7)), receipt.ReturnValue)
+	assert.Equal(t, []string{ContractStorageKey(storage, "k")}, receipt.Writes)
+	_, err = bc.ContractState.get(ContractStorageKey(storage, "k"))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(100), bc.AccountState.Balance(alice.PublicKey()))
+	assert.Equal(t, uint64(0), bc.AccountState.Balance(storage))
+
+	// the sender can not pay the value
+	msg.Value = 101
+	_, err = bc.Call(msg)
+	assert.NotNil(t, err)
+
+	// a failing call is reported in the receipt
+	msg.Value = 0
+	msg.GasLimit = 1
+	receipt, err = bc.Call(msg)
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	assert.Contains(t, receipt.Err, ErrOutOfGas.Error())
+	assert.Nil(t, receipt.ReturnValue)
+}
+
+func TestContractEnvironment(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	// stores every environment value under its own key
+	env := []byte{}
+	for i, key := range "cvhtxb" {
+		env = append(env, instrCaller+byte(i), instrPushBytes, 0x01, byte(key), instrStore)
+	}
+	callee := ContractAddress(alice.PublicKey(), 0)
+	caller := ContractAddress(alice.PublicKey(), 1)
+	call := callTx(t, alice, callee, 2, testGas)
+	call.Value = 5
+	assert.Nil(t, call.Sign(&alice))
+	nested := callTx(t, alice, caller, 3, testGas)
+	nested.Value = 7
+	assert.Nil(t, nested.Sign(&alice))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deployTx(t, alice, env, 0, testGas),
+		deployTx(t, alice, callCode(callee, nil, 2, 10_000), 1, testGas),
+	})))
+
+	stored := func(addr crypto.PublicKey, key string) []byte {
+		value, err := bc.ContractState.get(ContractStorageKey(addr, key))
+		assert.Nil(t, err)
+		return value
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{call})))
+	block, err := bc.GetBlock(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(alice.PublicKey()), stored(callee, "c"))
+	assert.Equal(t, wordBytes(newWord(5)), stored(callee, "v"))
+	assert.Equal(t, wordBytes(newWord(2)), stored(callee, "h"))
+	assert.Equal(t, wordBytes(newWord(uint64(block.TimeStamp))), stored(callee, "t"))
+	assert.Equal(t, []byte(block.Validator), stored(callee, "x"))
+	// the value is transferred before the code runs
+	assert.Equal(t, wordBytes(newWord(5)), stored(callee, "b"))
+
+	// a nested call sees the calling contract and the value it sent
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{nested})))
+	assert.Equal(t, []byte(caller), stored(callee, "c"))
+	assert.Equal(t, wordBytes(newWord(2)), stored(callee, "v"))
+	assert.Equal(t, wordBytes(newWord(3)), stored(callee, "h"))
+	assert.Equal(t, wordBytes(newWord(7)), stored(callee, "b"))
+	assert.Equal(t, uint64(5), bc.AccountState.Balance(caller))
+}
+
+func TestDryRun(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Alloc = GenesisAlloc{alice.PublicKey().String(): 100}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	// stores its call data under n, without call data it returns n
+	code := []byte{0x37, 0x20, 0x09, 0x1a, 0x2a, 0x01, 'n', 0x10, 0x1d, 0x1b, 0x36, 0x2a, 0x01, 'n', 0x0f}
+	addr := ContractAddress(alice.PublicKey(), 0)
+	set := func(nonce uint64, value string) *Transaction {
+		tx := callTx(t, alice, addr, nonce, testGas)
+		tx.CallData = []byte(value)
+		assert.Nil(t, tx.Sign(&alice))
+		return tx
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{deployTx(t, alice, code, 0, testGas)})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{set(1, "a")})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{set(2, "b")})))
+	root := bc.StateRoot()
+
+	// replaying the chain for an old height does not log its txs again
+	logged := 0
+	bc.Logger = log.LoggerFunc(func(...any) error {
+		logged++
+		return nil
+	})
+	read := NewTransaction(nil)
+	read.From = alice.PublicKey()
+	read.To = addr
+	for height, expected := range map[uint32]string{2: "a", 3: "b"} {
+		receipt, err := bc.CallAt(read, height)
+		assert.Nil(t, err)
+		assert.True(t, receipt.Success, receipt.Err)
+		assert.Equal(t, []byte(expected), receipt.ReturnValue)
+		assert.Greater(t, receipt.GasUsed, uint64(0))
+	}
+	assert.Equal(t, 0, logged)
+	receipt, err := bc.Call(read)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), receipt.ReturnValue)
+	// nothing was stored after block 1
+	receipt, err = bc.CallAt(read, 1)
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	_, err = bc.CallAt(read, 4)
+	assert.NotNil(t, err)
+
+	// a dry run on an old state writes to a throwaway snapshot
+	receipt, err = bc.CallAt(set(9, "z"), 2)
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, []string{ContractStorageKey(addr, "n")}, receipt.Writes)
+
+	// code runs on the storage of the receiver: "n" get "!" concat, log it
+	// under t and return it
+	query := []byte{0x2a, 0x01, 'n', 0x10, 0x2a, 0x01, '!', 0x28, 0x25, 0x01, 0x2a, 0x01, 't', 0x34, 0x1d}
+	receipt, err = bc.RunCodeAt(query, read, 2)
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, []byte("a!"), receipt.ReturnValue)
+	assert.Equal(t, []*Log{{Address: addr, Topic: []byte("t"), Data: []byte("a!")}}, receipt.Logs)
+
+	// without a receiver the code runs at a fresh address
+	anon := NewTransaction(nil)
+	anon.From = alice.PublicKey()
+	anon.Nonce = 3
+	receipt, err = bc.RunCodeAt([]byte{0x3e, 0x1d}, anon, bc.Height())
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success, receipt.Err)
+	assert.Equal(t, wordBytes(newWord(0)), receipt.ReturnValue)
+	_, err = bc.GetCode(ContractAddress(alice.PublicKey(), 3))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, root, bc.StateRoot())
+	deployed, err := bc.GetCode(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, code, deployed)
+}
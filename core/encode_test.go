@@ -41,6 +41,7 @@ func RandomBlock(height int) (*Block, error) {
 		Nonce:     nonce,
 		Height:    uint32(height),
 	}
+	header.LogsBloom.Add(prevBlock[:])
 
 	// 生成随机Transactions
 	// numTransactions := mathrand.Intn(50) // 随机生成交易数量
@@ -53,6 +54,12 @@ func RandomBlock(height int) (*Block, error) {
 		}
 	}
 
+	datahash, err := CalculateDatahash(transactions)
+	if err != nil {
+		return nil, err
+	}
+	header.DataHash = datahash
+
 	// 生成随机Validator
 	var validatorPubKey crypto.PublicKey
 	if _, err := rand.Read(validatorPubKey); err != nil {
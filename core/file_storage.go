@@ -0,0 +1,398 @@
+package core
+
+import (
+	"blockchain/pkg/e"
+	"blockchain/types"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultSegmentSize = 64 << 20
+
+	segmentPattern = "segment-%06d.dat"
+	// record layout: | length uint32 | crc32 uint32 | proto encoded block |
+	recordHeaderSize = 8
+)
+
+// ErrReadOnly is returned by Put of a read only FileStorage
+var ErrReadOnly = errors.New("storage: read only")
+
+var (
+	// errTornRecord marks a record at the end of a segment that was only
+	// partially written
+	errTornRecord = errors.New("torn record")
+	// errCorruptRecord marks a damaged record that more data follows, a crash
+	// during Put can not leave it behind
+	errCorruptRecord = errors.New("corrupted record")
+)
+
+type FileStorageOpts struct {
+	Dir string
+	// a new segment file is opened once the active one grows past SegmentSize
+	SegmentSize int64
+	// NoSync skips fsync after every write, only for tests
+	NoSync bool
+	// ReadOnly opens the segments of a storage another process may still
+	// write to, a torn record at the end is skipped instead of truncated and
+	// Put fails
+	ReadOnly bool
+}
+
+// recordPos locates one block record inside the segment files
+type recordPos struct {
+	segment int
+	offset  int64
+}
+
+// FileStorage keeps blocks in append-only segment files, the height, hash and
+// tx index is rebuilt by scanning the segments when the storage is opened.
+// Headers are small and kept in memory so header reads never touch the disk.
+type FileStorage struct {
+	FileStorageOpts
+	lock       sync.RWMutex
+	segments   []*os.File
+	activeSize int64
+	heights    []recordPos
+	headers    []*Header
+	hashes     map[types.Hash]uint32
+	txs        map[types.Hash]TxLocation
+}
+
+func NewFileStorage(opts FileStorageOpts) (*FileStorage, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.ReadOnly {
+		if _, err := os.Stat(opts.Dir); err != nil {
+			return nil, fmt.Errorf("storage: open dir failed: %w", err)
+		}
+	} else if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("storage: create dir failed: %w", err)
+	}
+	fs := &FileStorage{
+		FileStorageOpts: opts,
+		hashes:          make(map[types.Hash]uint32),
+		txs:             make(map[types.Hash]TxLocation),
+	}
+	if err := fs.recover(); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// recover opens every segment and indexes its records. A torn record at the
+// end of the last segment is what a crash in the middle of Put leaves behind,
+// it is truncated away. Damage anywhere else is reported as corruption.
+func (fs *FileStorage) recover() error {
+	names, err := filepath.Glob(filepath.Join(fs.Dir, "segment-*.dat"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if name != fs.segmentPath(i) {
+			return fmt.Errorf("storage: unexpected segment file %s", name)
+		}
+		flag := os.O_RDWR
+		if fs.ReadOnly {
+			flag = os.O_RDONLY
+		}
+		f, err := os.OpenFile(name, flag, 0o644)
+		if err != nil {
+			return err
+		}
+		fs.segments = append(fs.segments, f)
+
+		end, err := fs.scanSegment(i)
+		if err == nil {
+			fs.activeSize = end
+			continue
+		}
+		if i != len(names)-1 || !errors.Is(err, errTornRecord) {
+			return fmt.Errorf("storage: segment %s is corrupted: %w", name, err)
+		}
+		fs.activeSize = end
+		if fs.ReadOnly {
+			// the writer may be in the middle of this record
+			continue
+		}
+		if err := f.Truncate(end); err != nil {
+			return fmt.Errorf("storage: truncate torn record failed: %w", err)
+		}
+		if err := fs.sync(f); err != nil {
+			return err
+		}
+	}
+	if len(fs.segments) == 0 && !fs.ReadOnly {
+		return fs.openSegment()
+	}
+	return nil
+}
+
+// scanSegment indexes all records of a segment and returns the offset behind
+// the last valid one
+func (fs *FileStorage) scanSegment(segment int) (int64, error) {
+	f := fs.segments[segment]
+	var offset int64
+	for {
+		data, err := readRecord(f, offset)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		b := NewBlock(&Header{}, nil)
+		if err := b.Decode(NewBlockDecoder(bytes.NewReader(data))); err != nil {
+			return offset, err
+		}
+		if b.Height != uint32(len(fs.heights)) {
+			return offset, fmt.Errorf("unexpected block height %d, expected %d", b.Height, len(fs.heights))
+		}
+		fs.index(b, recordPos{segment: segment, offset: offset})
+		offset += recordHeaderSize + int64(len(data))
+	}
+}
+
+func readRecord(f *os.File, offset int64) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := f.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read record header at offset %d: %w", offset, err)
+	}
+	if n < recordHeaderSize {
+		return nil, fmt.Errorf("%w: short header at offset %d", errTornRecord, offset)
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// a torn length field must not turn into a huge allocation
+	if offset+recordHeaderSize+int64(size) > info.Size() {
+		follows, err := recordFollows(f, offset+recordHeaderSize, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		if follows {
+			return nil, fmt.Errorf("%w: length %d past the end at offset %d", errCorruptRecord, size, offset)
+		}
+		return nil, fmt.Errorf("%w: short data at offset %d", errTornRecord, offset)
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, offset+recordHeaderSize); err != nil {
+		return nil, fmt.Errorf("read record at offset %d: %w", offset, err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		// only the last record of a file can be torn
+		if offset+recordHeaderSize+int64(size) == info.Size() {
+			return nil, fmt.Errorf("%w: checksum mismatch at offset %d", errTornRecord, offset)
+		}
+		return nil, fmt.Errorf("%w: checksum mismatch at offset %d", errCorruptRecord, offset)
+	}
+	return data, nil
+}
+
+// recordFollows reports whether a record with a valid checksum starts between
+// from and end. A crash in Put leaves part of a single record behind, a
+// record after it means the length in front of it is damaged.
+func recordFollows(f *os.File, from, end int64) (bool, error) {
+	tail := make([]byte, end-from)
+	if _, err := f.ReadAt(tail, from); err != nil {
+		return false, fmt.Errorf("read records after offset %d: %w", from, err)
+	}
+	for i := 0; i+recordHeaderSize <= len(tail); i++ {
+		size := int64(binary.BigEndian.Uint32(tail[i:]))
+		if size == 0 || int64(i)+recordHeaderSize+size > int64(len(tail)) {
+			continue
+		}
+		data := tail[i+recordHeaderSize : int64(i)+recordHeaderSize+size]
+		if crc32.ChecksumIEEE(data) == binary.BigEndian.Uint32(tail[i+4:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (fs *FileStorage) index(b *Block, pos recordPos) {
+	fs.heights = append(fs.heights, pos)
+	fs.headers = append(fs.headers, b.Header)
+	fs.hashes[NewBlockHasher().Hash(b.Header)] = b.Height
+	for i, tx := range b.Transaction {
+		hash := tx.Hash(TxHasher{})
+		// the first inclusion wins
+		if _, ok := fs.txs[hash]; !ok {
+			fs.txs[hash] = TxLocation{Height: b.Height, Index: i}
+		}
+	}
+}
+
+func (fs *FileStorage) segmentPath(i int) string {
+	return filepath.Join(fs.Dir, fmt.Sprintf(segmentPattern, i))
+}
+
+func (fs *FileStorage) openSegment() error {
+	f, err := os.OpenFile(fs.segmentPath(len(fs.segments)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("storage: create segment failed: %w", err)
+	}
+	fs.segments = append(fs.segments, f)
+	fs.activeSize = 0
+	// make the new file name durable as well
+	if dir, err := os.Open(fs.Dir); err == nil {
+		defer dir.Close()
+		return fs.sync(dir)
+	}
+	return nil
+}
+
+func (fs *FileStorage) sync(f *os.File) error {
+	if fs.NoSync {
+		return nil
+	}
+	return f.Sync()
+}
+
+func (fs *FileStorage) Put(b *Block) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.ReadOnly {
+		return ErrReadOnly
+	}
+	if b.Height != uint32(len(fs.heights)) {
+		return fmt.Errorf("storage: can not put block at height %d, expected %d", b.Height, len(fs.heights))
+	}
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewBlockEncoder(buf)); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	if fs.activeSize > 0 && fs.activeSize+recordHeaderSize+int64(len(data)) > fs.SegmentSize {
+		if err := fs.openSegment(); err != nil {
+			return err
+		}
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(data))
+	record = append(record, data...)
+
+	segment := len(fs.segments) - 1
+	f := fs.segments[segment]
+	if _, err := f.WriteAt(record, fs.activeSize); err != nil {
+		// drop whatever part of the record reached the file
+		f.Truncate(fs.activeSize)
+		return fmt.Errorf("storage: write block failed: %w", err)
+	}
+	if err := fs.sync(f); err != nil {
+		return fmt.Errorf("storage: sync segment failed: %w", err)
+	}
+	fs.index(b, recordPos{segment: segment, offset: fs.activeSize})
+	fs.activeSize += int64(len(record))
+	return nil
+}
+
+func (fs *FileStorage) Get(height uint32) (*Block, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	if height >= uint32(len(fs.heights)) {
+		return nil, e.ErrBlockUnKnown
+	}
+	pos := fs.heights[height]
+	data, err := readRecord(fs.segments[pos.segment], pos.offset)
+	if err != nil {
+		return nil, fmt.Errorf("storage: read block %d failed: %w", height, err)
+	}
+	b := NewBlock(&Header{}, nil)
+	if err := b.Decode(NewBlockDecoder(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (fs *FileStorage) GetByHash(hash types.Hash) (*Block, error) {
+	fs.lock.RLock()
+	height, ok := fs.hashes[hash]
+	fs.lock.RUnlock()
+
+	if !ok {
+		return nil, e.ErrBlockUnKnown
+	}
+	return fs.Get(height)
+}
+
+func (fs *FileStorage) GetHeader(height uint32) (*Header, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	if height >= uint32(len(fs.headers)) {
+		return nil, e.ErrBlockUnKnown
+	}
+	return fs.headers[height], nil
+}
+
+func (fs *FileStorage) GetTx(hash types.Hash) (*Transaction, *TxLocation, error) {
+	fs.lock.RLock()
+	loc, ok := fs.txs[hash]
+	fs.lock.RUnlock()
+
+	if !ok {
+		return nil, nil, e.ErrTxUnKnown
+	}
+	b, err := fs.Get(loc.Height)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.Transaction[loc.Index], &loc, nil
+}
+
+func (fs *FileStorage) Head() (*Header, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	if len(fs.headers) == 0 {
+		return nil, e.ErrBlockUnKnown
+	}
+	return fs.headers[len(fs.headers)-1], nil
+}
+
+func (fs *FileStorage) Iterate(from, to uint32, fn func(*Block) error) error {
+	return iterate(fs, from, to, fn)
+}
+
+func (fs *FileStorage) Len() uint32 {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	return uint32(len(fs.heights))
+}
+
+func (fs *FileStorage) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	var err error
+	for _, f := range fs.segments {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	fs.segments = nil
+	return err
+}
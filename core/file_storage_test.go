@@ -0,0 +1,198 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFileStorage(t *testing.T, dir string, segmentSize int64) *FileStorage {
+	fs, err := NewFileStorage(FileStorageOpts{Dir: dir, SegmentSize: segmentSize, NoSync: true})
+	assert.Nil(t, err)
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestFileStorageConformance(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		return newTestFileStorage(t, t.TempDir(), 4096)
+	})
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+	// tiny segments so the blocks spread over several files
+	fs := newTestFileStorage(t, dir, 4096)
+	blocks := putRandomBlocks(t, fs, 20)
+	assert.Nil(t, fs.Close())
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.dat"))
+	assert.Greater(t, len(segments), 1)
+
+	fs = newTestFileStorage(t, dir, 4096)
+	assert.Equal(t, uint32(20), fs.Len())
+	for i, block := range blocks {
+		got, err := fs.Get(uint32(i))
+		assert.Nil(t, err)
+		assert.Equal(t, block, got)
+	}
+	putRandomBlocks(t, fs, 1)
+	assert.Equal(t, uint32(21), fs.Len())
+
+	tx := blocks[19].Transaction
+	if len(tx) > 0 {
+		_, loc, err := fs.GetTx(tx[0].Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, &TxLocation{Height: 19, Index: 0}, loc)
+	}
+}
+
+func TestFileStorageTruncateTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestFileStorage(t, dir, 0)
+	putRandomBlocks(t, fs, 3)
+	assert.Nil(t, fs.Close())
+
+	// cut the last record in half as a crash during Put would
+	path := filepath.Join(dir, "segment-000000.dat")
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	last := fs.heights[2].offset
+	assert.Nil(t, os.Truncate(path, last+(info.Size()-last)/2))
+
+	fs = newTestFileStorage(t, dir, 0)
+	assert.Equal(t, uint32(2), fs.Len())
+	info, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, last, info.Size())
+	putRandomBlocks(t, fs, 1)
+	assert.Equal(t, uint32(3), fs.Len())
+}
+
+func TestFileStorageReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestFileStorage(t, dir, 0)
+	putRandomBlocks(t, fs, 3)
+	assert.Nil(t, fs.Close())
+
+	// a writer in the middle of the last record
+	path := filepath.Join(dir, "segment-000000.dat")
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	last := fs.heights[2].offset
+	assert.Nil(t, os.Truncate(path, last+(info.Size()-last)/2))
+	torn, err := os.Stat(path)
+	assert.Nil(t, err)
+
+	ro, err := NewFileStorage(FileStorageOpts{Dir: dir, ReadOnly: true})
+	assert.Nil(t, err)
+	defer ro.Close()
+	assert.Equal(t, uint32(2), ro.Len())
+	info, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, torn.Size(), info.Size())
+	b, err := ro.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), b.Height)
+	assert.ErrorIs(t, ro.Put(NewBlock(&Header{Height: 2}, nil)), ErrReadOnly)
+
+	_, err = NewFileStorage(FileStorageOpts{Dir: filepath.Join(dir, "missing"), ReadOnly: true})
+	assert.NotNil(t, err)
+}
+
+func TestFileStorageCorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestFileStorage(t, dir, 4096)
+	putRandomBlocks(t, fs, 20)
+	assert.Nil(t, fs.Close())
+
+	// damage inside a sealed segment is not a torn write
+	f, err := os.OpenFile(filepath.Join(dir, "segment-000000.dat"), os.O_RDWR, 0o644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff}, recordHeaderSize+4)
+	assert.Nil(t, err)
+	f.Close()
+
+	_, err = NewFileStorage(FileStorageOpts{Dir: dir, SegmentSize: 4096, NoSync: true})
+	assert.NotNil(t, err)
+}
+
+func TestFileStorageCorruptedActiveSegment(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestFileStorage(t, dir, 0)
+	putRandomBlocks(t, fs, 5)
+	assert.Nil(t, fs.Close())
+
+	// a bad record with valid blocks behind it is not a torn write, the
+	// blocks after it must not be truncated away
+	path := filepath.Join(dir, "segment-000000.dat")
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff}, fs.heights[1].offset+recordHeaderSize+4)
+	assert.Nil(t, err)
+	f.Close()
+	before, err := os.Stat(path)
+	assert.Nil(t, err)
+
+	_, err = NewFileStorage(FileStorageOpts{Dir: dir, NoSync: true})
+	assert.ErrorIs(t, err, errCorruptRecord)
+	after, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+}
+
+func TestFileStorageCorruptedLength(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestFileStorage(t, dir, 0)
+	putRandomBlocks(t, fs, 5)
+	assert.Nil(t, fs.Close())
+
+	// a length pointing past the end is only torn when no record follows
+	path := filepath.Join(dir, "segment-000000.dat")
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0x7f, 0xff, 0xff, 0xff}, fs.heights[1].offset)
+	assert.Nil(t, err)
+	f.Close()
+	before, err := os.Stat(path)
+	assert.Nil(t, err)
+
+	_, err = NewFileStorage(FileStorageOpts{Dir: dir, NoSync: true})
+	assert.ErrorIs(t, err, errCorruptRecord)
+	after, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+}
+
+func TestBlockchainReloadFromFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	genesis := DefaultGenesis()
+	fs := newTestFileStorage(t, dir, 0)
+	bc, err := NewBlockChain(log.NewNopLogger(), fs, genesis)
+	assert.Nil(t, err)
+	pri := crypto.GenerateKeyPair()
+	for i := 1; i <= 5; i++ {
+		tx := NewTransaction([]byte{0x20, 0x01, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f})
+		tx.Nonce = uint64(i - 1)
+		tx.GasLimit = testGas
+		assert.Nil(t, tx.Sign(&pri))
+		assert.Nil(t, bc.AddBlockWithoutValidate(nextBlock(t, bc, []*Transaction{tx})))
+	}
+	assert.Nil(t, fs.Close())
+
+	fs = newTestFileStorage(t, dir, 0)
+	reloaded, err := NewBlockChain(log.NewNopLogger(), fs, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Height(), reloaded.Height())
+	assert.Equal(t, bc.Headers, reloaded.Headers)
+	assert.Equal(t, bc.ContractState.data, reloaded.ContractState.data)
+	assert.Equal(t, uint64(5), reloaded.AccountState.Nonce(pri.PublicKey()))
+
+	other := DefaultGenesis()
+	other.ChainID = "other"
+	_, err = NewBlockChain(log.NewNopLogger(), fs, other)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,100 @@
+package core
+
+import "math"
+
+// DefaultBlockGasLimit applies when the genesis does not set a gas limit
+const DefaultBlockGasLimit uint64 = 10_000_000
+
+// static gas cost per instruction, charged before the instruction runs
+var gasTable = map[byte]uint64{
+	instrPush1:        1,
+	instrPush2:        1,
+	instrPush4:        1,
+	instrPush8:        1,
+	instrPush32:       1,
+	instrPushByte1:    1,
+	instrPushBytes:    1,
+	instrDup:          1,
+	instrSwap:         1,
+	instrPop:          1,
+	instrConcat:       3,
+	instrAdd:          3,
+	instrMinus:        3,
+	instrMult:         5,
+	instrDiv:          5,
+	instrPack:         3,
+	instrStore:        100,
+	instrGet:          20,
+	instrEq:           3,
+	instrLt:           3,
+	instrGt:           3,
+	instrAnd:          3,
+	instrOr:           3,
+	instrNot:          3,
+	instrJump:         8,
+	instrJumpi:        10,
+	instrJumpdest:     1,
+	instrHalt:         0,
+	instrReturn:       0,
+	instrMod:          5,
+	instrSdiv:         5,
+	instrSmod:         5,
+	instrSlt:          3,
+	instrSgt:          3,
+	instrExp:          10,
+	instrShl:          3,
+	instrShr:          3,
+	instrSar:          3,
+	instrLog:          50,
+	instrCall:         100,
+	instrCallData:     2,
+	instrCallDataSize: 2,
+	instrCallDataLoad: 3,
+	instrCaller:       2,
+	instrCallValue:    2,
+	instrHeight:       2,
+	instrTimestamp:    2,
+	instrValidator:    2,
+	instrSelfBalance:  5,
+}
+
+// dynamic gas costs, charged on top of the static cost
+const (
+	// gasPackByte is charged per byte packed by instrPack
+	gasPackByte uint64 = 1
+	// gasStoreByte is charged per byte of a stored value
+	gasStoreByte uint64 = 5
+	// gasConcatByte is charged per byte of the result of instrConcat
+	gasConcatByte uint64 = 1
+	// gasCallDataByte is charged per byte pushed by instrCallData
+	gasCallDataByte uint64 = 1
+	// gasExpByte is charged per byte of the exponent of instrExp
+	gasExpByte uint64 = 10
+	// gasLogByte is charged per byte of topic and data of instrLog
+	gasLogByte uint64 = 2
+)
+
+// TotalGasLimit sums the gas limits of txx, saturating instead of overflowing
+func TotalGasLimit(txx []*Transaction) uint64 {
+	var total uint64
+	for _, tx := range txx {
+		if total > math.MaxUint64-tx.GasLimit {
+			return math.MaxUint64
+		}
+		total += tx.GasLimit
+	}
+	return total
+}
+
+// FitGasLimit splits txx at the first transaction that would take the total
+// gas limit above limit. Order is kept so sender nonces stay contiguous.
+func FitGasLimit(txx []*Transaction, limit uint64) (fit, rest []*Transaction) {
+	var total uint64
+	for i, tx := range txx {
+		if tx.GasLimit > limit-total {
+			return txx[:i], txx[i:]
+		}
+		total += tx.GasLimit
+	}
+	return txx, nil
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// 1 [F] 1 pack store
+var storeProgram = []byte{0x20, 0x01, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f}
+
+// push, push, push, pack 1 byte, store a 32 byte word
+const storeProgramGas = 1 + 1 + 1 + 3 + gasPackByte + 100 + wordSize*gasStoreByte
+
+func TestVMGas(t *testing.T) {
+	vm := NewVM(storeProgram, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, storeProgramGas, vm.gasUsed)
+
+	// exactly enough gas
+	vm = NewVM(storeProgram, NewContractState(), storeProgramGas)
+	assert.Nil(t, vm.run())
+
+	// running out uses up the whole limit
+	vm = NewVM(storeProgram, NewContractState(), storeProgramGas-1)
+	assert.ErrorIs(t, vm.run(), ErrOutOfGas)
+	assert.Equal(t, storeProgramGas-1, vm.gasUsed)
+
+	vm = NewVM(storeProgram, NewContractState(), 0)
+	assert.ErrorIs(t, vm.run(), ErrOutOfGas)
+	assert.Nil(t, NewVM(nil, NewContractState(), 0).run())
+}
+
+func TestFitGasLimit(t *testing.T) {
+	txx := make([]*Transaction, 4)
+	for i := range txx {
+		txx[i] = NewTransaction(nil)
+		txx[i].GasLimit = 40
+	}
+	assert.Equal(t, uint64(160), TotalGasLimit(txx))
+
+	fit, rest := FitGasLimit(txx, 100)
+	assert.Equal(t, txx[:2], fit)
+	assert.Equal(t, txx[2:], rest)
+
+	fit, rest = FitGasLimit(txx, 160)
+	assert.Len(t, fit, 4)
+	assert.Empty(t, rest)
+}
+
+func TestOutOfGasReverts(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	addr := ContractAddress(pri.PublicKey(), 0)
+	tx := callTx(t, pri, addr, 1, storeProgramGas-1)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deployTx(t, pri, storeProgram, 0, testGas),
+		tx,
+	})))
+
+	receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	assert.Contains(t, receipt.Err, ErrOutOfGas.Error())
+	assert.Equal(t, tx.GasLimit, receipt.GasUsed)
+	assert.Empty(t, receipt.Writes)
+	_, err = bc.ContractState.get(ContractStorageKey(addr, "F"))
+	assert.NotNil(t, err)
+	// the nonce is still used up
+	assert.Equal(t, uint64(2), bc.AccountState.Nonce(pri.PublicKey()))
+
+	tx = callTx(t, pri, addr, 2, testGas)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{tx})))
+	receipt, err = bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Success)
+	assert.Equal(t, storeProgramGas, receipt.GasUsed)
+}
+
+func TestBlockGasLimit(t *testing.T) {
+	genesis := DefaultGenesis()
+	genesis.GasLimit = 1000
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	txx := make([]*Transaction, 3)
+	for i := range txx {
+		txx[i] = NewTransaction(storeProgram)
+		txx[i].Nonce = uint64(i)
+		txx[i].GasLimit = 400
+		assert.Nil(t, txx[i].Sign(&pri))
+	}
+	assert.ErrorContains(t, bc.AddBlock(nextBlock(t, bc, txx)), "gas limit")
+
+	valid, later := bc.FilterTransactions(txx)
+	assert.Empty(t, later)
+	fit, rest := FitGasLimit(valid, genesis.BlockGasLimit())
+	assert.Len(t, fit, 2)
+	assert.Len(t, rest, 1)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, fit)))
+
+	// a tx above the block limit can never be included
+	huge := NewTransaction(storeProgram)
+	huge.Nonce = 2
+	huge.GasLimit = 1001
+	assert.Nil(t, huge.Sign(&pri))
+	valid, later = bc.FilterTransactions([]*Transaction{huge})
+	assert.Empty(t, valid)
+	assert.Empty(t, later)
+}
+
+func TestFailedTxRollback(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	// store, then divide by zero
+	failing := append(append([]byte{}, storeProgram...), 0x20, 0x00, 0x20, 0x01, 0x12)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deployTx(t, pri, failing, 0, testGas),
+		deployTx(t, pri, storeProgram, 1, testGas),
+	})))
+	tx := callTx(t, pri, ContractAddress(pri.PublicKey(), 0), 2, testGas)
+	okAddr := ContractAddress(pri.PublicKey(), 1)
+	ok := callTx(t, pri, okAddr, 3, testGas)
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{tx})))
+	receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	assert.Empty(t, receipt.Writes)
+	assert.Greater(t, receipt.GasUsed, storeProgramGas)
+	_, err = bc.ContractState.get(ContractStorageKey(ContractAddress(pri.PublicKey(), 0), "F"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{ok})))
+	value, err := bc.ContractState.get(ContractStorageKey(okAddr, "F"))
+	assert.Nil(t, err)
+	assert.Equal(t, wordBytes(newWord(1)), value)
+}
+
+func TestGasTableCoversOpcodes(t *testing.T) {
+	for op, info := range opcodes {
+		_, ok := gasTable[op]
+		assert.True(t, ok, info.Name)
+		named, ok := OpcodeByName(info.Name)
+		assert.True(t, ok, info.Name)
+		assert.Equal(t, op, named)
+	}
+	assert.Len(t, gasTable, len(opcodes))
+}
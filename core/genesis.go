@@ -0,0 +1,228 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/types"
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Genesis is the chain configuration every node of a chain has to agree on,
+// the genesis block and the initial state are derived from it.
+type Genesis struct {
+	ChainID string `json:"chainId" yaml:"chainId"`
+	// Timestamp of the genesis block in Unix nanoseconds like every block
+	Timestamp int64 `json:"timestamp" yaml:"timestamp"`
+	// BlockTime is a duration such as "5s"
+	BlockTime string `json:"blockTime" yaml:"blockTime"`
+	// GasLimit caps the summed gas limits of the transactions in a block, zero
+	// means DefaultBlockGasLimit
+	GasLimit uint64 `json:"gasLimit,omitempty" yaml:"gasLimit"`
+	// Validators are hex encoded public keys allowed to sign blocks, an empty
+	// set lets anyone sign
+	Validators []string `json:"validators" yaml:"validators"`
+	// Alloc is the initial balance per hex encoded public key
+	Alloc GenesisAlloc `json:"alloc" yaml:"alloc"`
+	// Storage preloads the contract state, values are hex encoded. Keys are
+	// contract state keys such as ContractCodeKey and ContractStorageKey, so a
+	// genesis can deploy contracts.
+	Storage map[string]string `json:"storage" yaml:"storage"`
+}
+
+func DefaultGenesis() *Genesis {
+	return &Genesis{
+		ChainID:   "blockchain-dev",
+		BlockTime: "5s",
+	}
+}
+
+// LoadGenesis reads a genesis specification, the format is picked by the
+// file extension: .json, .yaml or .yml
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read genesis failed: %w", err)
+	}
+	g := &Genesis{}
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(g)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(g)
+	default:
+		return nil, fmt.Errorf("unknown genesis format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode genesis failed: %w", err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Genesis) Validate() error {
+	if g.ChainID == "" {
+		return fmt.Errorf("genesis: chain id is empty")
+	}
+	if _, err := g.BlockDuration(); err != nil {
+		return err
+	}
+	for _, v := range g.Validators {
+		if _, err := decodePublicKey(v); err != nil {
+			return fmt.Errorf("genesis: validator %s: %w", v, err)
+		}
+	}
+	for key := range g.Alloc {
+		if _, err := decodePublicKey(key); err != nil {
+			return fmt.Errorf("genesis: alloc %s: %w", key, err)
+		}
+	}
+	for key, value := range g.Storage {
+		data, err := decodeHex(value)
+		if err != nil {
+			return fmt.Errorf("genesis: storage %s: %w", key, err)
+		}
+		// preloaded code skips the deploy, it is verified here instead
+		if strings.HasPrefix(key, codeKeyPrefix) {
+			if err := VerifyCode(data); err != nil {
+				return fmt.Errorf("genesis: storage %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Genesis) BlockGasLimit() uint64 {
+	if g.GasLimit == 0 {
+		return DefaultBlockGasLimit
+	}
+	return g.GasLimit
+}
+
+func (g *Genesis) BlockDuration() (time.Duration, error) {
+	d, err := time.ParseDuration(g.BlockTime)
+	if err != nil {
+		return 0, fmt.Errorf("genesis: invalid block time %q: %w", g.BlockTime, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("genesis: block time must be positive")
+	}
+	return d, nil
+}
+
+// Hash commits to the whole specification. encoding/json writes struct
+// fields in order and sorts map keys, so the encoding is deterministic.
+func (g *Genesis) Hash() (types.Hash, error) {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// Block derives the genesis block, its data hash commits to the specification
+// so chains with different configurations never share a genesis
+func (g *Genesis) Block() (*Block, error) {
+	hash, err := g.Hash()
+	if err != nil {
+		return nil, err
+	}
+	contracts, accounts, err := g.state()
+	if err != nil {
+		return nil, err
+	}
+	header := &Header{
+		Version:   1,
+		Height:    0,
+		DataHash:  hash,
+		TimeStamp: g.Timestamp,
+		StateRoot: stateRoot(contracts, accounts),
+	}
+	return NewBlock(header, nil), nil
+}
+
+// state builds the initial contract and account state
+func (g *Genesis) state() (*contractState, *accountState, error) {
+	alloc, err := g.alloc()
+	if err != nil {
+		return nil, nil, err
+	}
+	storage, err := g.contractStorage()
+	if err != nil {
+		return nil, nil, err
+	}
+	contracts := NewContractState()
+	for key, value := range storage {
+		contracts.put(key, value)
+	}
+	return contracts, NewAccountState(alloc), nil
+}
+
+func (g *Genesis) IsValidator(pub crypto.PublicKey) bool {
+	if len(g.Validators) == 0 {
+		return true
+	}
+	key := pub.String()
+	for _, v := range g.Validators {
+		if strings.EqualFold(strings.TrimPrefix(v, "0x"), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// alloc returns the allocation keyed the way the account state keys accounts
+func (g *Genesis) alloc() (GenesisAlloc, error) {
+	alloc := make(GenesisAlloc, len(g.Alloc))
+	for key, balance := range g.Alloc {
+		pub, err := decodePublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("genesis: alloc %s: %w", key, err)
+		}
+		alloc[pub.String()] += balance
+	}
+	return alloc, nil
+}
+
+// contractStorage returns the decoded storage preload
+func (g *Genesis) contractStorage() (map[string][]byte, error) {
+	storage := make(map[string][]byte, len(g.Storage))
+	for key, value := range g.Storage {
+		data, err := decodeHex(value)
+		if err != nil {
+			return nil, fmt.Errorf("genesis: storage %s: %w", key, err)
+		}
+		storage[key] = data
+	}
+	return storage, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
+
+func decodePublicKey(s string) (crypto.PublicKey, error) {
+	key, err := decodeHex(s)
+	if err != nil {
+		return nil, err
+	}
+	if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), key); x == nil {
+		return nil, fmt.Errorf("not a compressed public key")
+	}
+	return key, nil
+}
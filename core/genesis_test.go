@@ -0,0 +1,116 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeGenesis(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadGenesis(t *testing.T) {
+	validator := crypto.GenerateKeyPair().PublicKey().String()
+	alice := crypto.GenerateKeyPair().PublicKey().String()
+
+	jsonPath := writeGenesis(t, "genesis.json", fmt.Sprintf(`{
+	"chainId": "testnet",
+	"timestamp": 1700000000,
+	"blockTime": "2s",
+	"validators": ["%s"],
+	"alloc": {"%s": 1000},
+	"storage": {"owner": "0x616c696365"}
+}`, validator, alice))
+	yamlPath := writeGenesis(t, "genesis.yaml", fmt.Sprintf(`
+chainId: testnet
+timestamp: 1700000000
+blockTime: 2s
+validators:
+  - "%s"
+alloc:
+  "%s": 1000
+storage:
+  owner: "0x616c696365"
+`, validator, alice))
+
+	fromJSON, err := LoadGenesis(jsonPath)
+	assert.Nil(t, err)
+	fromYAML, err := LoadGenesis(yamlPath)
+	assert.Nil(t, err)
+	assert.Equal(t, fromJSON, fromYAML)
+
+	blockTime, err := fromJSON.BlockDuration()
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, blockTime)
+
+	// both formats derive the same genesis block
+	b1, err := fromJSON.Block()
+	assert.Nil(t, err)
+	b2, err := fromYAML.Block()
+	assert.Nil(t, err)
+	assert.Equal(t, NewBlockHasher().Hash(b1.Header), NewBlockHasher().Hash(b2.Header))
+	assert.Equal(t, int64(1700000000), b1.TimeStamp)
+
+	// any change to the spec changes the genesis block
+	fromYAML.Alloc[alice] = 1001
+	b3, err := fromYAML.Block()
+	assert.Nil(t, err)
+	assert.NotEqual(t, NewBlockHasher().Hash(b1.Header), NewBlockHasher().Hash(b3.Header))
+}
+
+func TestLoadGenesisInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"genesis.json": `{"blockTime": "5s"}`,
+		"genesis.yml":  "chainId: x\nblockTime: soon\n",
+		"unknown.json": `{"chainId": "x", "blockTime": "5s", "gasPrice": 1}`,
+		"alloc.json":   `{"chainId": "x", "blockTime": "5s", "alloc": {"abcd": 1}}`,
+		"storage.json": `{"chainId": "x", "blockTime": "5s", "storage": {"k": "zz"}}`,
+		// a jump to a missing jumpdest does not verify
+		"code.json":    `{"chainId": "x", "blockTime": "5s", "storage": {"` + ContractCodeKey(make([]byte, ContractAddressSize)) + `": "200119"}}`,
+		"genesis.toml": `chainId = "x"`,
+	} {
+		_, err := LoadGenesis(writeGenesis(t, name, content))
+		assert.NotNil(t, err, name)
+	}
+
+	// preloaded code is verified like a deploy
+	genesis := DefaultGenesis()
+	genesis.Storage = map[string]string{ContractCodeKey(make([]byte, ContractAddressSize)): "200119"}
+	_, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.ErrorIs(t, err, ErrInvalidJump)
+}
+
+func TestBlockchainFromGenesis(t *testing.T) {
+	validator := crypto.GenerateKeyPair()
+	alice := crypto.GenerateKeyPair()
+	genesis := DefaultGenesis()
+	genesis.Validators = []string{validator.PublicKey().String()}
+	genesis.Alloc = GenesisAlloc{"0x" + alice.PublicKey().String(): 500}
+	genesis.Storage = map[string]string{"owner": "616c696365"}
+
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500), bc.AccountState.Balance(alice.PublicKey()))
+	value, err := bc.ContractState.get("owner")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), value)
+
+	// only the genesis validators may sign blocks
+	header, _ := bc.GetHeader(0)
+	block, err := NewBLockFromHeader(header, nil)
+	assert.Nil(t, err)
+	block.StateRoot = header.StateRoot
+	assert.Nil(t, block.Sign(crypto.GenerateKeyPair()))
+	assert.NotNil(t, bc.AddBlock(block))
+	assert.Nil(t, block.Sign(validator))
+	assert.Nil(t, bc.AddBlock(block))
+}
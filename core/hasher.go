@@ -30,13 +30,24 @@ func NewTxHasher() TxHasher {
 	return TxHasher{}
 }
 
+// Hash length prefixes the variable sized fields, otherwise bytes could move
+// between them without changing the hash, an empty To turns a call into a
+// deploy
 func (TxHasher) Hash(tx *Transaction) types.Hash {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, tx.Data)
-	binary.Write(buf, binary.LittleEndian, tx.To)
-	binary.Write(buf, binary.LittleEndian, tx.From)
+	writeBytes(buf, tx.Data)
+	writeBytes(buf, tx.To)
+	writeBytes(buf, tx.From)
 	binary.Write(buf, binary.LittleEndian, tx.Value)
 	binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	binary.Write(buf, binary.LittleEndian, tx.GasLimit)
+	writeBytes(buf, tx.CallData)
 
 	return types.Hash(sha256.Sum256(buf.Bytes()))
 }
+
+// writeBytes writes the length of b followed by b
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+	buf.Write(b)
+}
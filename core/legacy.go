@@ -0,0 +1,51 @@
+package core
+
+// Legacy bytecode put the operand of a push before the push, "3 0x0a" pushed
+// 3. A byte is an operand when the byte after it is a legacy push and it is
+// not a push itself. The legacy vm ran every byte, so an operand that is a
+// push byte, as in "1 0x0a 0x0a", also pushes its own operand: 1 and then 10
+// are pushed. Only a push byte at the start has no operand and is just the
+// operand of the push after it, "0x0c 0x0c" pushes the byte 0x0c.
+
+func isLegacyPush(instr byte) bool {
+	return instr == instrPushInt || instr == instrPushByte
+}
+
+func isLegacyOperand(data []byte, ip int) bool {
+	return ip+1 < len(data) && isLegacyPush(data[ip+1]) && (ip == 0 || !isLegacyPush(data[ip]))
+}
+
+// isLegacyInstr reports whether instr existed before the prefix encoding
+func isLegacyInstr(instr byte) bool {
+	return instr >= instrPushInt && instr <= instrReturn
+}
+
+// DecodeLegacy rewrites legacy bytecode to the current encoding. Every legacy
+// push becomes a two byte prefix push, so as long as no two pushes share an
+// operand byte, instruction offsets and with them jump destinations stay the
+// same.
+func DecodeLegacy(data []byte) ([]byte, error) {
+	code := make([]byte, 0, len(data))
+	for ip := 0; ip < len(data); ip++ {
+		if isLegacyOperand(data, ip) {
+			continue
+		}
+		instr := data[ip]
+		if !isLegacyInstr(instr) {
+			return nil, &ExecutionError{IP: ip, Op: instr, Err: ErrUnknownOpcode}
+		}
+		if !isLegacyPush(instr) {
+			code = append(code, instr)
+			continue
+		}
+		if ip == 0 {
+			return nil, &ExecutionError{IP: ip, Op: instr, Err: ErrTruncatedCode}
+		}
+		if instr == instrPushInt {
+			code = append(code, instrPush1, data[ip-1])
+		} else {
+			code = append(code, instrPushByte1, data[ip-1])
+		}
+	}
+	return code, nil
+}
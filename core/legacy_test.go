@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLegacy(t *testing.T) {
+	// 1 [F O O] 3 pack store
+	legacy := []byte{0x01, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+	code, err := DecodeLegacy(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x20, 0x01, 0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x0f}, code)
+
+	vm := NewVM(code, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	value, err := vm.contractstate.get("OOF")
+	assert.Nil(t, err)
+	assert.Equal(t, wordBytes(newWord(1)), value)
+
+	// opcode values work as legacy immediates, offsets are kept so the jump
+	// still lands on the jumpdest at 5
+	legacy = []byte{0x05, 0x0a, 0x19, 0x0b, 0x0a, 0x1b, 0x12, 0x0a}
+	code, err = DecodeLegacy(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x20, 0x05, 0x19, 0x20, 0x0b, 0x1b, 0x20, 0x12}, code)
+	vm = NewVM(code, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{uint64(0x12)}, stackItems(vm))
+
+	// push bytes as operands: a leading one is only an operand, later ones
+	// also push like the legacy vm ran them
+	code, err = DecodeLegacy([]byte{0x0a, 0x0a, 0x0c, 0x0c, 0x01, 0x0a, 0x0a})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x20, 0x0a, 0x29, 0x0a, 0x29, 0x0c, 0x20, 0x01, 0x20, 0x0a}, code)
+	vm = NewVM(code, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{uint64(0x0a), byte(0x0a), byte(0x0c), uint64(0x01), uint64(0x0a)}, stackItems(vm))
+
+	_, err = DecodeLegacy([]byte{0x0a})
+	assert.ErrorIs(t, err, ErrTruncatedCode)
+	_, err = DecodeLegacy([]byte{0x01, 0x0a, 0x20})
+	assert.ErrorIs(t, err, ErrUnknownOpcode)
+}
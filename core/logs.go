@@ -0,0 +1,98 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/types"
+	"bytes"
+	"fmt"
+)
+
+// Log is an event emitted by a contract
+type Log struct {
+	// Address is the contract that emitted the log
+	Address crypto.PublicKey
+	Topic   []byte
+	Data    []byte
+
+	// where the log was emitted, set once its transaction succeeded
+	TxHash types.Hash
+	Height uint32
+	// TxIndex is the position of the transaction in the block, Index the
+	// position of the log among the logs of the block
+	TxIndex int
+	Index   int
+}
+
+// LogFilter selects logs, an empty list matches everything
+type LogFilter struct {
+	// FromHeight and ToHeight are the first and the last block searched
+	FromHeight uint32
+	ToHeight   uint32
+	// Addresses matches logs of any of the contracts
+	Addresses []crypto.PublicKey
+	// Topics matches logs with any of the topics
+	Topics [][]byte
+}
+
+func (f *LogFilter) match(l *Log) bool {
+	return containsBytes(addressBytes(f.Addresses), l.Address) && containsBytes(f.Topics, l.Topic)
+}
+
+// mayMatch reports whether a block with bloom can hold a matching log
+func (f *LogFilter) mayMatch(bloom *Bloom) bool {
+	return bloom.TestAny(addressBytes(f.Addresses)) && bloom.TestAny(f.Topics)
+}
+
+func addressBytes(addrs []crypto.PublicKey) [][]byte {
+	values := make([][]byte, len(addrs))
+	for i, addr := range addrs {
+		values[i] = addr
+	}
+	return values
+}
+
+// containsBytes reports whether value is in values, any value is in an empty
+// list
+func containsBytes(values [][]byte, value []byte) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if bytes.Equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterLogs returns the logs matching f in chain order. ToHeight is capped at
+// the chain head, blocks whose bloom rules out a match are skipped without
+// reading their receipts.
+func (bc *Blockchain) FilterLogs(f LogFilter) ([]*Log, error) {
+	to := min(f.ToHeight, bc.Height())
+	if f.FromHeight > to {
+		return nil, fmt.Errorf("invalid log filter range %d to %d", f.FromHeight, f.ToHeight)
+	}
+	logs := []*Log{}
+	for height := f.FromHeight; height <= to; height++ {
+		header, err := bc.GetHeader(height)
+		if err != nil {
+			return nil, err
+		}
+		if !f.mayMatch(&header.LogsBloom) {
+			continue
+		}
+		receipts, err := bc.Receipts.GetBlockReceipts(height)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range receipts {
+			for _, l := range r.Logs {
+				if f.match(l) {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
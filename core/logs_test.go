@@ -0,0 +1,119 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// emitCode logs data under topic
+func emitCode(topic, data string) []byte {
+	code := append([]byte{instrPushBytes, byte(len(data))}, data...)
+	code = append(code, instrPushBytes, byte(len(topic)))
+	code = append(code, topic...)
+	return append(code, instrLog)
+}
+
+func TestBloom(t *testing.T) {
+	var bloom Bloom
+	for i := 0; i < 20; i++ {
+		bloom.Add([]byte(fmt.Sprint("value", i)))
+	}
+	for i := 0; i < 20; i++ {
+		assert.True(t, bloom.Test([]byte(fmt.Sprint("value", i))))
+	}
+	misses := 0
+	for i := 0; i < 1000; i++ {
+		if !bloom.Test([]byte(fmt.Sprint("other", i))) {
+			misses++
+		}
+	}
+	assert.Greater(t, misses, 990)
+	assert.True(t, bloom.TestAny(nil))
+	assert.False(t, bloom.TestAny([][]byte{[]byte("other")}))
+}
+
+func TestLogs(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	transfers := ContractAddress(pri.PublicKey(), 0)
+	votes := ContractAddress(pri.PublicKey(), 1)
+	failing := ContractAddress(pri.PublicKey(), 2)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		deployTx(t, pri, append(emitCode("transfer", "a"), emitCode("approve", "b")...), 0, testGas),
+		deployTx(t, pri, emitCode("vote", "c"), 1, testGas),
+		// logs and then divides by zero
+		deployTx(t, pri, append(emitCode("transfer", "x"), 0x20, 0x00, 0x20, 0x01, 0x12), 2, testGas),
+	})))
+	// block 1 has no logs
+	header, _ := bc.GetHeader(1)
+	assert.Equal(t, Bloom{}, header.LogsBloom)
+
+	call := callTx(t, pri, transfers, 3, testGas)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{
+		call,
+		callTx(t, pri, failing, 4, testGas),
+		callTx(t, pri, votes, 5, testGas),
+	})))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{callTx(t, pri, votes, 6, testGas)})))
+
+	receipt, err := bc.GetReceipt(call.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, []*Log{
+		{Address: transfers, Topic: []byte("transfer"), Data: []byte("a"), TxHash: receipt.TxHash, Height: 2, TxIndex: 0, Index: 0},
+		{Address: transfers, Topic: []byte("approve"), Data: []byte("b"), TxHash: receipt.TxHash, Height: 2, TxIndex: 0, Index: 1},
+	}, receipt.Logs)
+
+	header, _ = bc.GetHeader(2)
+	assert.True(t, header.LogsBloom.Test(transfers))
+	assert.True(t, header.LogsBloom.Test([]byte("vote")))
+	// the failed call leaves no logs
+	assert.False(t, header.LogsBloom.Test(failing))
+
+	topics := func(logs []*Log) []string {
+		names := []string{}
+		for _, l := range logs {
+			names = append(names, fmt.Sprintf("%d:%d:%s", l.Height, l.Index, l.Topic))
+		}
+		return names
+	}
+	for _, c := range []struct {
+		filter   LogFilter
+		expected []string
+	}{
+		{LogFilter{ToHeight: 100}, []string{"2:0:transfer", "2:1:approve", "2:2:vote", "3:0:vote"}},
+		{LogFilter{FromHeight: 3, ToHeight: 3}, []string{"3:0:vote"}},
+		{LogFilter{ToHeight: 100, Addresses: []crypto.PublicKey{votes}}, []string{"2:2:vote", "3:0:vote"}},
+		{LogFilter{ToHeight: 100, Topics: [][]byte{[]byte("approve"), []byte("vote")}}, []string{"2:1:approve", "2:2:vote", "3:0:vote"}},
+		{LogFilter{ToHeight: 100, Addresses: []crypto.PublicKey{votes}, Topics: [][]byte{[]byte("transfer")}}, []string{}},
+		{LogFilter{ToHeight: 100, Addresses: []crypto.PublicKey{failing}}, []string{}},
+	} {
+		logs, err := bc.FilterLogs(c.filter)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, topics(logs))
+	}
+	_, err = bc.FilterLogs(LogFilter{FromHeight: 5, ToHeight: 10})
+	assert.NotNil(t, err)
+}
+
+func TestLogsBloomValidation(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{deployTx(t, pri, emitCode("t", "d"), 0, testGas)})))
+
+	block := nextBlock(t, bc, []*Transaction{callTx(t, pri, ContractAddress(pri.PublicKey(), 0), 1, testGas)})
+	block.LogsBloom = Bloom{}
+	assert.Nil(t, block.Sign(pri))
+	assert.ErrorContains(t, bc.AddBlock(block), "logs bloom")
+
+	assert.Nil(t, bc.CalculateHeader(block))
+	assert.Nil(t, block.Sign(pri))
+	assert.Nil(t, bc.AddBlock(block))
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"blockchain/types"
+	"crypto/sha256"
+	"fmt"
+)
+
+// leaves and inner nodes are hashed with different prefixes, so a proof can
+// never pass an inner node off as a leaf
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+func merkleLeaf(h types.Hash) types.Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, h[:]...))
+}
+
+func merkleNode(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// MerkleRoot builds a binary merkle tree over leaves. An odd node at the end of
+// a level is carried up unchanged. The root of no leaves is the zero hash.
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf)
+	}
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return level[0]
+}
+
+func nextMerkleLevel(level []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+	return next
+}
+
+// MerkleStep is one sibling on the way from a leaf to the root
+type MerkleStep struct {
+	Hash types.Hash
+	// Left is set when the sibling is the left child
+	Left bool
+}
+
+// MerkleProof proves that a leaf is part of a merkle root
+type MerkleProof struct {
+	Steps []MerkleStep
+}
+
+// NewMerkleProof returns the proof for leaves[index]
+func NewMerkleProof(leaves []types.Hash, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("merkle leaf index %d out of range [0, %d)", index, len(leaves))
+	}
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf)
+	}
+	proof := &MerkleProof{}
+	for len(level) > 1 {
+		// a carried up node has no sibling on this level
+		if sibling := index ^ 1; sibling < len(level) {
+			proof.Steps = append(proof.Steps, MerkleStep{
+				Hash: level[sibling],
+				Left: sibling < index,
+			})
+		}
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// Verify checks that leaf is included in root
+func (p *MerkleProof) Verify(root, leaf types.Hash) bool {
+	if p == nil {
+		return false
+	}
+	h := merkleLeaf(leaf)
+	for _, step := range p.Steps {
+		if step.Left {
+			h = merkleNode(step.Hash, h)
+		} else {
+			h = merkleNode(h, step.Hash)
+		}
+	}
+	return h == root
+}
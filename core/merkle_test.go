@@ -0,0 +1,83 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/types"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func randomLeaves(n int) []types.Hash {
+	leaves := make([]types.Hash, n)
+	for i := range leaves {
+		leaves[i] = sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+	}
+	return leaves
+}
+
+func TestMerkleRoot(t *testing.T) {
+	assert.True(t, MerkleRoot(nil).IsZero())
+
+	leaves := randomLeaves(3)
+	root := MerkleRoot(leaves)
+	// the odd leaf is carried up
+	expected := merkleNode(merkleNode(merkleLeaf(leaves[0]), merkleLeaf(leaves[1])), merkleLeaf(leaves[2]))
+	assert.Equal(t, expected, root)
+
+	leaves[0], leaves[1] = leaves[1], leaves[0]
+	assert.NotEqual(t, root, MerkleRoot(leaves))
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := randomLeaves(n)
+		root := MerkleRoot(leaves)
+		for i := range leaves {
+			proof, err := NewMerkleProof(leaves, i)
+			assert.Nil(t, err)
+			assert.True(t, proof.Verify(root, leaves[i]), "n=%d i=%d", n, i)
+			// the proof is bound to its leaf
+			if n > 1 {
+				assert.False(t, proof.Verify(root, leaves[(i+1)%n]), "n=%d i=%d", n, i)
+			}
+		}
+	}
+	_, err := NewMerkleProof(randomLeaves(4), 4)
+	assert.NotNil(t, err)
+
+	leaves := randomLeaves(8)
+	proof, _ := NewMerkleProof(leaves, 5)
+	proof.Steps[1].Hash[0] ^= 0xff
+	assert.False(t, proof.Verify(MerkleRoot(leaves), leaves[5]))
+	assert.False(t, (*MerkleProof)(nil).Verify(MerkleRoot(leaves), leaves[5]))
+}
+
+func TestTxInclusionProof(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	txx := make([]*Transaction, 5)
+	for i := range txx {
+		txx[i] = NewTransaction([]byte{0x20, 0x01})
+		txx[i].Nonce = uint64(i)
+		assert.Nil(t, txx[i].Sign(&pri))
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, txx)))
+
+	for _, tx := range txx {
+		header, proof, err := bc.GetTxProof(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.True(t, VerifyTxProof(header, tx.Hash(TxHasher{}), proof))
+	}
+	other := RandomTxWithSignature()
+	header, proof, _ := bc.GetTxProof(txx[0].Hash(TxHasher{}))
+	assert.False(t, VerifyTxProof(header, other.Hash(TxHasher{}), proof))
+
+	block, _ := bc.GetBlock(1)
+	_, err = block.TxProof(other.Hash(TxHasher{}))
+	assert.NotNil(t, err)
+}
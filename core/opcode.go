@@ -0,0 +1,87 @@
+package core
+
+// OpInfo describes an instruction for tools that work on bytecode
+type OpInfo struct {
+	Name string
+	// Immediate is the number of operand bytes after the opcode. instrPushBytes
+	// reads a length byte and then that many bytes, it reports -1.
+	Immediate int
+}
+
+var opcodes = map[byte]OpInfo{
+	instrAdd:          {"add", 0},
+	instrPack:         {"pack", 0},
+	instrMinus:        {"minus", 0},
+	instrStore:        {"store", 0},
+	instrGet:          {"get", 0},
+	instrMult:         {"mult", 0},
+	instrDiv:          {"div", 0},
+	instrEq:           {"eq", 0},
+	instrLt:           {"lt", 0},
+	instrGt:           {"gt", 0},
+	instrAnd:          {"and", 0},
+	instrOr:           {"or", 0},
+	instrNot:          {"not", 0},
+	instrJump:         {"jump", 0},
+	instrJumpi:        {"jumpi", 0},
+	instrJumpdest:     {"jumpdest", 0},
+	instrHalt:         {"halt", 0},
+	instrReturn:       {"return", 0},
+	instrPush1:        {"push1", 1},
+	instrPush2:        {"push2", 2},
+	instrPush4:        {"push4", 4},
+	instrPush8:        {"push8", 8},
+	instrPush32:       {"push32", 32},
+	instrDup:          {"dup", 1},
+	instrSwap:         {"swap", 1},
+	instrPop:          {"pop", 0},
+	instrConcat:       {"concat", 0},
+	instrPushByte1:    {"pushbyte", 1},
+	instrPushBytes:    {"pushbytes", -1},
+	instrMod:          {"mod", 0},
+	instrSdiv:         {"sdiv", 0},
+	instrSmod:         {"smod", 0},
+	instrSlt:          {"slt", 0},
+	instrSgt:          {"sgt", 0},
+	instrExp:          {"exp", 0},
+	instrShl:          {"shl", 0},
+	instrShr:          {"shr", 0},
+	instrSar:          {"sar", 0},
+	instrLog:          {"log", 0},
+	instrCall:         {"call", 0},
+	instrCallData:     {"calldata", 0},
+	instrCallDataSize: {"calldatasize", 0},
+	instrCallDataLoad: {"calldataload", 0},
+	instrCaller:       {"caller", 0},
+	instrCallValue:    {"callvalue", 0},
+	instrHeight:       {"height", 0},
+	instrTimestamp:    {"timestamp", 0},
+	instrValidator:    {"validator", 0},
+	instrSelfBalance:  {"selfbalance", 0},
+}
+
+var opcodesByName = func() map[string]byte {
+	byName := make(map[string]byte, len(opcodes))
+	for op, info := range opcodes {
+		byName[info.Name] = op
+	}
+	return byName
+}()
+
+// LookupOpcode returns the description of op, unknown opcodes report false
+func LookupOpcode(op byte) (OpInfo, bool) {
+	info, ok := opcodes[op]
+	return info, ok
+}
+
+// OpcodeByName returns the opcode of a mnemonic
+func OpcodeByName(name string) (byte, bool) {
+	op, ok := opcodesByName[name]
+	return op, ok
+}
+
+// InstrSize returns the size of the instruction at ip including its
+// immediate, it may run past the end of truncated code
+func InstrSize(code []byte, ip int) int {
+	return 1 + immediateSize(code, ip)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/types"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Precompiles are native contracts at reserved addresses, instrCall reaches
+// them like any contract. Each one costs a fixed amount of gas. The checking
+// precompiles fail the call when the check does not hold, so the success word
+// of instrCall is their result.
+var (
+	// PrecompileSha256 returns the sha256 of its input
+	PrecompileSha256 = precompileAddress(0x01)
+	// PrecompileKeccak256 returns the keccak256 of its input
+	PrecompileKeccak256 = precompileAddress(0x02)
+	// PrecompileVerify checks a signature, its input is a 33 byte public key,
+	// the 32 byte R and S of the crypto.Signature and then the signed data
+	PrecompileVerify = precompileAddress(0x03)
+	// PrecompileMerkle checks a merkle proof, its input is the 32 byte root and
+	// leaf followed by the proof steps of 33 bytes each, a byte that is 1 when
+	// the sibling is on the left and the sibling hash
+	PrecompileMerkle = precompileAddress(0x04)
+)
+
+// ErrCheckFailed is returned by a checking precompile whose check does not
+// hold
+var ErrCheckFailed = errors.New("precompile check failed")
+
+type precompile struct {
+	gas uint64
+	run func(input []byte) ([]byte, error)
+}
+
+// precompiles is keyed by the address string
+var precompiles = map[string]precompile{
+	PrecompileSha256.String():    {60, precompileSha256},
+	PrecompileKeccak256.String(): {60, precompileKeccak256},
+	PrecompileVerify.String():    {3000, precompileVerify},
+	PrecompileMerkle.String():    {300, precompileMerkle},
+}
+
+// precompileAddress is a contract address of zeros ending in n
+func precompileAddress(n byte) crypto.PublicKey {
+	addr := make(crypto.PublicKey, ContractAddressSize)
+	addr[ContractAddressSize-1] = n
+	return addr
+}
+
+// runPrecompile charges the gas of p and runs it on the call data
+func (vm *VM) runPrecompile(p precompile) error {
+	if err := vm.useGas(p.gas); err != nil {
+		return err
+	}
+	ret, err := p.run(vm.input)
+	if err != nil {
+		return err
+	}
+	vm.ret = ret
+	return nil
+}
+
+func precompileSha256(input []byte) ([]byte, error) {
+	hash := sha256.Sum256(input)
+	return hash[:], nil
+}
+
+func precompileKeccak256(input []byte) ([]byte, error) {
+	hash := crypto.Keccak256(input)
+	return hash[:], nil
+}
+
+// publicKeySize is the size of a compressed public key
+const publicKeySize = 33
+
+func precompileVerify(input []byte) ([]byte, error) {
+	if len(input) < publicKeySize+2*wordSize {
+		return nil, fmt.Errorf("%w: verify input of %d bytes", ErrBadOperand, len(input))
+	}
+	pub := crypto.PublicKey(input[:publicKeySize])
+	sig := crypto.Signature{
+		R: new(big.Int).SetBytes(input[publicKeySize : publicKeySize+wordSize]),
+		S: new(big.Int).SetBytes(input[publicKeySize+wordSize : publicKeySize+2*wordSize]),
+	}
+	if !sig.Verify(input[publicKeySize+2*wordSize:], pub) {
+		return nil, ErrCheckFailed
+	}
+	return wordBytes(wordBool(true)), nil
+}
+
+func precompileMerkle(input []byte) ([]byte, error) {
+	const stepSize = 1 + wordSize
+	if len(input) < 2*wordSize || (len(input)-2*wordSize)%stepSize != 0 {
+		return nil, fmt.Errorf("%w: merkle input of %d bytes", ErrBadOperand, len(input))
+	}
+	root := types.HashFromBytes(input[:wordSize])
+	leaf := types.HashFromBytes(input[wordSize : 2*wordSize])
+	proof := &MerkleProof{}
+	for steps := input[2*wordSize:]; len(steps) > 0; steps = steps[stepSize:] {
+		proof.Steps = append(proof.Steps, MerkleStep{
+			Hash: types.HashFromBytes(steps[1:stepSize]),
+			Left: steps[0] == 1,
+		})
+	}
+	if !proof.Verify(root, leaf) {
+		return nil, ErrCheckFailed
+	}
+	return wordBytes(wordBool(true)), nil
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/types"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runPrecompileCall calls the precompile at addr with input and returns the
+// stack afterwards
+func runPrecompileCall(t *testing.T, addr crypto.PublicKey, input []byte, gas uint32) []any {
+	vm, _, _ := newCallVM(nil, nil, testGas)
+	vm.data = callCode(addr, input, 0, gas)
+	assert.Nil(t, vm.run())
+	return stackItems(vm)
+}
+
+func TestPrecompileHashes(t *testing.T) {
+	sha := sha256.Sum256([]byte("abc"))
+	assert.Equal(t, []any{sha[:], uint64(1)}, runPrecompileCall(t, PrecompileSha256, []byte("abc"), 1000))
+	keccak := crypto.Keccak256([]byte("abc"))
+	assert.Equal(t, []any{keccak[:], uint64(1)}, runPrecompileCall(t, PrecompileKeccak256, []byte("abc"), 1000))
+
+	// the fixed price is charged on top of the call
+	vm, _, _ := newCallVM(nil, nil, testGas)
+	vm.data = callCode(PrecompileSha256, []byte("abc"), 0, 1000)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, 4+gasTable[instrCall]+precompiles[PrecompileSha256.String()].gas, vm.gasUsed)
+
+	// too little gas fails the call but not the caller
+	assert.Equal(t, []any{[]byte{}, uint64(0)}, runPrecompileCall(t, PrecompileSha256, []byte("abc"), 59))
+}
+
+func TestPrecompileVerify(t *testing.T) {
+	pri := crypto.GenerateKeyPair()
+	data := []byte("signed data")
+	sig, err := pri.Sign(data)
+	assert.Nil(t, err)
+	input := func(pub crypto.PublicKey, data []byte) []byte {
+		input := append([]byte{}, pub...)
+		input = append(input, sig.R.FillBytes(make([]byte, 32))...)
+		input = append(input, sig.S.FillBytes(make([]byte, 32))...)
+		return append(input, data...)
+	}
+
+	assert.Equal(t, []any{wordBytes(newWord(1)), uint64(1)}, runPrecompileCall(t, PrecompileVerify, input(pri.PublicKey(), data), 5000))
+	failed := []any{[]byte{}, uint64(0)}
+	assert.Equal(t, failed, runPrecompileCall(t, PrecompileVerify, input(pri.PublicKey(), []byte("other data")), 5000))
+	other := crypto.GenerateKeyPair()
+	assert.Equal(t, failed, runPrecompileCall(t, PrecompileVerify, input(other.PublicKey(), data), 5000))
+	// not a public key
+	assert.Equal(t, failed, runPrecompileCall(t, PrecompileVerify, input(make([]byte, 33), data), 5000))
+	assert.Equal(t, failed, runPrecompileCall(t, PrecompileVerify, input(pri.PublicKey(), data)[:90], 5000))
+}
+
+func TestPrecompileMerkle(t *testing.T) {
+	leaves := []types.Hash{}
+	for i := 0; i < 5; i++ {
+		leaves = append(leaves, sha256.Sum256([]byte(fmt.Sprint("leaf", i))))
+	}
+	root := MerkleRoot(leaves)
+	input := func(leaf types.Hash, proof *MerkleProof) []byte {
+		input := append(root.HashToBytes(), leaf.HashToBytes()...)
+		for _, step := range proof.Steps {
+			left := byte(0)
+			if step.Left {
+				left = 1
+			}
+			input = append(append(input, left), step.Hash.HashToBytes()...)
+		}
+		return input
+	}
+
+	for i := range leaves {
+		proof, err := NewMerkleProof(leaves, i)
+		assert.Nil(t, err)
+		assert.Equal(t, []any{wordBytes(newWord(1)), uint64(1)}, runPrecompileCall(t, PrecompileMerkle, input(leaves[i], proof), 1000), i)
+	}
+	proof, err := NewMerkleProof(leaves, 1)
+	assert.Nil(t, err)
+	failed := []any{[]byte{}, uint64(0)}
+	assert.Equal(t, failed, runPrecompileCall(t, PrecompileMerkle, input(leaves[2], proof), 1000))
+	// a proof step is cut short
+	assert.Equal(t, failed, runPrecompileCall(t, PrecompileMerkle, input(leaves[1], proof)[:70], 1000))
+}
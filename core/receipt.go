@@ -0,0 +1,82 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/pkg/e"
+	"blockchain/types"
+	"sync"
+)
+
+// Receipt records the outcome of executing one included transaction
+type Receipt struct {
+	TxHash    types.Hash
+	BlockHash types.Hash
+	Height    uint32
+	Index     int
+	Success   bool
+	// Err is the vm error message of a failed transaction
+	Err string
+	// Writes lists the state keys the transaction stored
+	Writes []string
+	// GasUsed is the gas the vm charged, all of GasLimit when it ran out
+	GasUsed uint64
+	// ContractAddress is the address of the contract a deploy created
+	ContractAddress crypto.PublicKey
+	// Logs emitted by a successful transaction
+	Logs []*Log
+	// ReturnValue is what a successful contract call returned, words are 32
+	// byte big endian
+	ReturnValue []byte
+}
+
+// ReceiptStore indexes receipts by tx hash and by block height. Receipts are
+// derived from executing the chain, so they are rebuilt when the chain is
+// reloaded instead of being persisted.
+type ReceiptStore struct {
+	lock     sync.RWMutex
+	receipts map[types.Hash]*Receipt
+	blocks   map[uint32][]*Receipt
+}
+
+func NewReceiptStore() *ReceiptStore {
+	return &ReceiptStore{
+		receipts: make(map[types.Hash]*Receipt),
+		blocks:   make(map[uint32][]*Receipt),
+	}
+}
+
+// Put adds the receipts of the block at height
+func (rs *ReceiptStore) Put(height uint32, receipts []*Receipt) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.blocks[height] = receipts
+	for _, r := range receipts {
+		// the first inclusion wins, same as the storage tx index
+		if _, ok := rs.receipts[r.TxHash]; !ok {
+			rs.receipts[r.TxHash] = r
+		}
+	}
+}
+
+func (rs *ReceiptStore) Get(hash types.Hash) (*Receipt, error) {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+
+	r, ok := rs.receipts[hash]
+	if !ok {
+		return nil, e.ErrTxUnKnown
+	}
+	return r, nil
+}
+
+func (rs *ReceiptStore) GetBlockReceipts(height uint32) ([]*Receipt, error) {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+
+	receipts, ok := rs.blocks[height]
+	if !ok {
+		return nil, e.ErrBlockUnKnown
+	}
+	return receipts, nil
+}
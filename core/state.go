@@ -1,8 +1,11 @@
 package core
 
 import (
+	"blockchain/crypto"
+	"blockchain/pkg/e"
 	"fmt"
 	"log/slog"
+	"math"
 )
 
 // should set a interface
@@ -13,36 +16,244 @@ type state interface {
 	get(key string) error
 }
 
-//  todo account state
+// GenesisAlloc maps hex encoded public keys to their initial balance
+type GenesisAlloc map[string]uint64
+
+type account struct {
+	Balance uint64
+	// Nonce is the nonce the next transaction of the account must carry
+	Nonce uint64
+}
+
+// accountState and contractState are layered: snapshot returns a copy on
+// write layer whose reads fall through to its parent and whose writes stay in
+// the layer. commit merges a layer into its parent, a discarded layer is
+// simply dropped and leaves the parent untouched.
+type accountState struct {
+	parent   *accountState
+	accounts map[string]*account
+}
+
+func NewAccountState(alloc GenesisAlloc) *accountState {
+	s := &accountState{
+		accounts: make(map[string]*account, len(alloc)),
+	}
+	for key, balance := range alloc {
+		s.accounts[key] = &account{Balance: balance}
+	}
+	return s
+}
+
+func (s *accountState) lookup(key string) *account {
+	for layer := s; layer != nil; layer = layer.parent {
+		if acc, ok := layer.accounts[key]; ok {
+			return acc
+		}
+	}
+	return nil
+}
+
+func (s *accountState) Balance(pub crypto.PublicKey) uint64 {
+	if acc := s.lookup(pub.String()); acc != nil {
+		return acc.Balance
+	}
+	return 0
+}
+
+func (s *accountState) Nonce(pub crypto.PublicKey) uint64 {
+	if acc := s.lookup(pub.String()); acc != nil {
+		return acc.Nonce
+	}
+	return 0
+}
+
+// account returns the account of pub for writing, an account of a parent
+// layer is copied into this layer first
+func (s *accountState) account(pub crypto.PublicKey) *account {
+	key := pub.String()
+	if acc, ok := s.accounts[key]; ok {
+		return acc
+	}
+	acc := &account{}
+	if parent := s.parent.lookup(key); parent != nil {
+		*acc = *parent
+	}
+	s.accounts[key] = acc
+	return acc
+}
+
+// transfer checks the tx nonce, moves tx.Value from sender to receiver and
+// bumps the sender nonce. Nothing changes when an error is returned.
+func (s *accountState) transfer(tx *Transaction) error {
+	if nonce := s.Nonce(tx.From); tx.Nonce != nonce {
+		return fmt.Errorf("%w: got %d, expected %d", e.ErrInvalidNonce, tx.Nonce, nonce)
+	}
+	if err := s.move(tx.From, tx.receiver(), tx.Value); err != nil {
+		return err
+	}
+	s.account(tx.From).Nonce++
+	return nil
+}
+
+// useNonce checks the tx nonce and bumps the sender nonce without moving
+// tx.Value. Nothing changes when an error is returned.
+func (s *accountState) useNonce(tx *Transaction) error {
+	if nonce := s.Nonce(tx.From); tx.Nonce != nonce {
+		return fmt.Errorf("%w: got %d, expected %d", e.ErrInvalidNonce, tx.Nonce, nonce)
+	}
+	s.account(tx.From).Nonce++
+	return nil
+}
+
+// move moves value from one account to another. Nothing changes when an
+// error is returned.
+func (s *accountState) move(from, to crypto.PublicKey, value uint64) error {
+	if value == 0 {
+		return nil
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("transfer of %d has no receiver", value)
+	}
+	if balance := s.Balance(from); balance < value {
+		return fmt.Errorf("%w: has %d, needs %d", e.ErrInsufficientBalance, balance, value)
+	}
+	if s.Balance(to) > math.MaxUint64-value {
+		return fmt.Errorf("receiver balance overflow")
+	}
+	s.account(from).Balance -= value
+	s.account(to).Balance += value
+	return nil
+}
+
+func (s *accountState) snapshot() *accountState {
+	return &accountState{
+		parent:   s,
+		accounts: make(map[string]*account),
+	}
+}
+
+// commit merges the layer into its parent, it is a no-op on the base layer
+func (s *accountState) commit() {
+	if s.parent == nil {
+		return
+	}
+	for key, acc := range s.accounts {
+		s.parent.accounts[key] = acc
+	}
+	s.accounts = make(map[string]*account)
+}
+
+// entries returns every account visible from this layer
+func (s *accountState) entries() map[string]*account {
+	if s.parent == nil {
+		return s.accounts
+	}
+	accounts := s.parent.entries()
+	merged := make(map[string]*account, len(accounts)+len(s.accounts))
+	for key, acc := range accounts {
+		merged[key] = acc
+	}
+	for key, acc := range s.accounts {
+		merged[key] = acc
+	}
+	return merged
+}
 
 type contractState struct {
 	//  todo  contract state , maybe one contract pair one data
-	data map[string][]byte
+	parent *contractState
+	data   map[string][]byte
+	// keys deleted in this layer that may still exist in a parent
+	deleted map[string]struct{}
 }
 
 func NewContractState() *contractState {
 	return &contractState{
-		data: make(map[string][]byte, 1024),
+		data:    make(map[string][]byte, 1024),
+		deleted: make(map[string]struct{}),
+	}
+}
+
+func (s *contractState) snapshot() *contractState {
+	return &contractState{
+		parent:  s,
+		data:    make(map[string][]byte),
+		deleted: make(map[string]struct{}),
 	}
 }
 
+// commit merges the layer into its parent, it is a no-op on the base layer
+func (s *contractState) commit() {
+	if s.parent == nil {
+		return
+	}
+	for key := range s.deleted {
+		delete(s.parent.data, key)
+		if s.parent.parent != nil {
+			s.parent.deleted[key] = struct{}{}
+		}
+	}
+	// values are never modified in place, sharing them is fine
+	for key, value := range s.data {
+		s.parent.data[key] = value
+		delete(s.parent.deleted, key)
+	}
+	s.data = make(map[string][]byte)
+	s.deleted = make(map[string]struct{})
+}
+
+func (s *contractState) lookup(key string) ([]byte, bool) {
+	for layer := s; layer != nil; layer = layer.parent {
+		if value, ok := layer.data[key]; ok {
+			return value, true
+		}
+		if _, ok := layer.deleted[key]; ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// entries returns every key value pair visible from this layer
+func (s *contractState) entries() map[string][]byte {
+	if s.parent == nil {
+		return s.data
+	}
+	data := s.parent.entries()
+	merged := make(map[string][]byte, len(data)+len(s.data))
+	for key, value := range data {
+		if _, ok := s.deleted[key]; !ok {
+			merged[key] = value
+		}
+	}
+	for key, value := range s.data {
+		merged[key] = value
+	}
+	return merged
+}
+
 func (s *contractState) put(key string, data []byte) error {
 	s.data[key] = data
+	delete(s.deleted, key)
 	return nil
 }
 
 func (s *contractState) del(key string) error {
-	if _, ok := s.data[key]; !ok {
+	if _, ok := s.lookup(key); !ok {
 		slog.Info("contractState: delete key err", "err:", fmt.Errorf("data dont exist"))
 	}
 	delete(s.data, key)
+	if s.parent != nil {
+		s.deleted[key] = struct{}{}
+	}
 	return nil
 }
 
 func (s *contractState) get(key string) ([]byte, error) {
-	if _, ok := s.data[key]; !ok {
+	value, ok := s.lookup(key)
+	if !ok {
 		slog.Info("contractState key err", "err:", fmt.Errorf("data dont exist"))
 		return nil, fmt.Errorf("contractState:%v", "data dont exist")
 	}
-	return s.data[key], nil
+	return value, nil
 }
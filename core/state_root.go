@@ -0,0 +1,89 @@
+package core
+
+import (
+	"blockchain/pkg/e"
+	"blockchain/types"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// The state tree is a merkle tree over every account and contract storage
+// entry, sorted by key. Accounts and contract storage live in one key space
+// separated by these prefixes.
+const (
+	accountKeyPrefix  = "account/"
+	contractKeyPrefix = "contract/"
+)
+
+type stateEntry struct {
+	key   string
+	value []byte
+}
+
+// stateLeaf hashes one entry, the key length keeps key and value apart
+func stateLeaf(key string, value []byte) types.Hash {
+	buf := make([]byte, 4, 4+len(key)+len(value))
+	binary.BigEndian.PutUint32(buf, uint32(len(key)))
+	buf = append(buf, key...)
+	buf = append(buf, value...)
+	return sha256.Sum256(buf)
+}
+
+func encodeAccount(acc *account) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], acc.Balance)
+	binary.BigEndian.PutUint64(buf[8:], acc.Nonce)
+	return buf
+}
+
+func stateEntries(cs *contractState, as *accountState) []stateEntry {
+	accounts, data := as.entries(), cs.entries()
+	entries := make([]stateEntry, 0, len(data)+len(accounts))
+	for key, acc := range accounts {
+		entries = append(entries, stateEntry{key: accountKeyPrefix + key, value: encodeAccount(acc)})
+	}
+	for key, value := range data {
+		entries = append(entries, stateEntry{key: contractKeyPrefix + key, value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+func stateLeaves(entries []stateEntry) []types.Hash {
+	leaves := make([]types.Hash, len(entries))
+	for i, entry := range entries {
+		leaves[i] = stateLeaf(entry.key, entry.value)
+	}
+	return leaves
+}
+
+// stateRoot commits to the whole contract and account state
+func stateRoot(cs *contractState, as *accountState) types.Hash {
+	return MerkleRoot(stateLeaves(stateEntries(cs, as)))
+}
+
+// stateProof returns the value stored under the full state key and its proof
+func stateProof(cs *contractState, as *accountState, key string) ([]byte, *MerkleProof, error) {
+	entries := stateEntries(cs, as)
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].key >= key
+	})
+	if i == len(entries) || entries[i].key != key {
+		return nil, nil, e.ErrKeyUnKnown
+	}
+	proof, err := NewMerkleProof(stateLeaves(entries), i)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries[i].value, proof, nil
+}
+
+// VerifyStateProof checks that the contract storage key holds value in the
+// state committed by root. It needs nothing but a trusted header, so clients
+// can read state from an untrusted node.
+func VerifyStateProof(root types.Hash, key string, value []byte, proof *MerkleProof) bool {
+	return proof.Verify(root, stateLeaf(contractKeyPrefix+key, value))
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"blockchain/pkg/e"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRootIsOrderIndependent(t *testing.T) {
+	a := NewContractState()
+	a.put("foo", []byte("1"))
+	a.put("bar", []byte("2"))
+	b := NewContractState()
+	b.put("bar", []byte("2"))
+	b.put("foo", []byte("1"))
+	accounts := NewAccountState(nil)
+	assert.Equal(t, stateRoot(a, accounts), stateRoot(b, accounts))
+
+	b.put("foo", []byte("3"))
+	assert.NotEqual(t, stateRoot(a, accounts), stateRoot(b, accounts))
+}
+
+func TestStateProof(t *testing.T) {
+	genesis := DefaultGenesis()
+	genesis.Storage = map[string]string{"owner": "616c696365"}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	// the contract deployed at nonce stores value under F
+	store := func(value byte, nonce uint64) []*Transaction {
+		// value [F] 1 pack store
+		code := []byte{0x20, value, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f}
+		return []*Transaction{
+			deployTx(t, pri, code, nonce, testGas),
+			callTx(t, pri, ContractAddress(pri.PublicKey(), nonce), nonce+1, testGas),
+		}
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, store(1, 0))))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, store(2, 2))))
+
+	for height, expected := range map[uint32]byte{1: 1, 2: 2} {
+		key := ContractStorageKey(ContractAddress(pri.PublicKey(), 2*uint64(height-1)), "F")
+		header, _ := bc.GetHeader(height)
+		value, proof, err := bc.GetStateProof(height, key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value[wordSize-1])
+		assert.True(t, VerifyStateProof(header.StateRoot, key, value, proof))
+
+		// a forged value or a proof for another block does not verify
+		assert.False(t, VerifyStateProof(header.StateRoot, key, []byte("forged"), proof))
+		other, _ := bc.GetHeader(3 - height)
+		assert.False(t, VerifyStateProof(other.StateRoot, key, value, proof))
+	}
+
+	header, _ := bc.GetHeader(0)
+	value, proof, err := bc.GetStateProof(0, "owner")
+	assert.Nil(t, err)
+	assert.True(t, VerifyStateProof(header.StateRoot, "owner", value, proof))
+
+	key := ContractStorageKey(ContractAddress(pri.PublicKey(), 2), "F")
+	_, _, err = bc.GetStateProof(1, key)
+	assert.ErrorIs(t, err, e.ErrKeyUnKnown)
+	_, _, err = bc.GetStateProof(5, key)
+	assert.ErrorIs(t, err, e.ErrBlockUnKnown)
+	// account entries are not contract storage
+	_, _, err = bc.GetStateProof(2, pri.PublicKey().String())
+	assert.ErrorIs(t, err, e.ErrKeyUnKnown)
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractStateSnapshot(t *testing.T) {
+	base := NewContractState()
+	base.put("a", []byte("1"))
+	base.put("b", []byte("2"))
+
+	layer := base.snapshot()
+	layer.put("a", []byte("3"))
+	layer.put("c", []byte("4"))
+	layer.del("b")
+
+	value, err := layer.get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), value)
+	_, err = layer.get("b")
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("3"), "c": []byte("4")}, layer.entries())
+	// the parent is untouched until commit
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, base.entries())
+
+	// a nested layer commits into its parent only
+	nested := layer.snapshot()
+	nested.put("b", []byte("5"))
+	nested.del("c")
+	nested.commit()
+	assert.Equal(t, map[string][]byte{"a": []byte("3"), "b": []byte("5")}, layer.entries())
+	assert.Len(t, base.entries(), 2)
+
+	layer.commit()
+	assert.Equal(t, map[string][]byte{"a": []byte("3"), "b": []byte("5")}, base.data)
+
+	// a discarded layer is dropped
+	discarded := base.snapshot()
+	discarded.put("a", []byte("6"))
+	value, _ = base.get("a")
+	assert.Equal(t, []byte("3"), value)
+}
+
+func TestAccountStateSnapshot(t *testing.T) {
+	alice := crypto.GenerateKeyPair().PublicKey()
+	bob := crypto.GenerateKeyPair().PublicKey()
+	base := NewAccountState(GenesisAlloc{alice.String(): 100})
+
+	layer := base.snapshot()
+	assert.Nil(t, layer.transfer(&Transaction{From: alice, To: bob, Value: 40}))
+	assert.Equal(t, uint64(60), layer.Balance(alice))
+	assert.Equal(t, uint64(40), layer.Balance(bob))
+	assert.Equal(t, uint64(100), base.Balance(alice))
+	assert.Equal(t, uint64(0), base.Nonce(alice))
+	assert.Len(t, layer.entries(), 2)
+
+	layer.commit()
+	assert.Equal(t, uint64(60), base.Balance(alice))
+	assert.Equal(t, uint64(40), base.Balance(bob))
+	assert.Equal(t, uint64(1), base.Nonce(alice))
+}
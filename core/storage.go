@@ -1,16 +1,143 @@
 package core
 
+import (
+	"blockchain/pkg/e"
+	"blockchain/types"
+	"fmt"
+	"sync"
+)
+
 type Storage interface {
 	Put(*Block) error
+	Get(height uint32) (*Block, error)
+	GetByHash(hash types.Hash) (*Block, error)
+	GetHeader(height uint32) (*Header, error)
+	// GetTx finds a stored transaction and where it was included
+	GetTx(hash types.Hash) (*Transaction, *TxLocation, error)
+	// Head returns the header of the latest stored block
+	Head() (*Header, error)
+	// Iterate calls fn for every block in [from, to], a fn error stops the iteration
+	Iterate(from, to uint32, fn func(*Block) error) error
+	// Len returns the number of stored blocks, the next height to put is Len()
+	Len() uint32
+}
+
+// TxLocation is the position of a transaction in the chain
+type TxLocation struct {
+	Height uint32
+	Index  int
+}
+
+// iterate is the range check shared by the storage implementations
+func iterate(s Storage, from, to uint32, fn func(*Block) error) error {
+	if from > to {
+		return fmt.Errorf("storage: invalid range [%d, %d]", from, to)
+	}
+	if to >= s.Len() {
+		return e.ErrBlockUnKnown
+	}
+	for height := from; height <= to; height++ {
+		b, err := s.Get(height)
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type MemoryStorage struct {
+	lock   sync.RWMutex
+	blocks []*Block
+	hashes map[types.Hash]uint32
+	txs    map[types.Hash]TxLocation
 }
 
 func NewStorage() *MemoryStorage {
-	return &MemoryStorage{}
+	return &MemoryStorage{
+		hashes: make(map[types.Hash]uint32),
+		txs:    make(map[types.Hash]TxLocation),
+	}
 }
 
 func (ms *MemoryStorage) Put(b *Block) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if b.Height != uint32(len(ms.blocks)) {
+		return fmt.Errorf("storage: can not put block at height %d, expected %d", b.Height, len(ms.blocks))
+	}
+	ms.blocks = append(ms.blocks, b)
+	ms.hashes[NewBlockHasher().Hash(b.Header)] = b.Height
+	for i, tx := range b.Transaction {
+		hash := tx.Hash(TxHasher{})
+		// the first inclusion wins
+		if _, ok := ms.txs[hash]; !ok {
+			ms.txs[hash] = TxLocation{Height: b.Height, Index: i}
+		}
+	}
 	return nil
 }
+
+func (ms *MemoryStorage) Get(height uint32) (*Block, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	if height >= uint32(len(ms.blocks)) {
+		return nil, e.ErrBlockUnKnown
+	}
+	return ms.blocks[height], nil
+}
+
+func (ms *MemoryStorage) GetByHash(hash types.Hash) (*Block, error) {
+	ms.lock.RLock()
+	height, ok := ms.hashes[hash]
+	ms.lock.RUnlock()
+
+	if !ok {
+		return nil, e.ErrBlockUnKnown
+	}
+	return ms.Get(height)
+}
+
+func (ms *MemoryStorage) GetHeader(height uint32) (*Header, error) {
+	b, err := ms.Get(height)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header, nil
+}
+
+func (ms *MemoryStorage) GetTx(hash types.Hash) (*Transaction, *TxLocation, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	loc, ok := ms.txs[hash]
+	if !ok {
+		return nil, nil, e.ErrTxUnKnown
+	}
+	return ms.blocks[loc.Height].Transaction[loc.Index], &loc, nil
+}
+
+func (ms *MemoryStorage) Head() (*Header, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	if len(ms.blocks) == 0 {
+		return nil, e.ErrBlockUnKnown
+	}
+	return ms.blocks[len(ms.blocks)-1].Header, nil
+}
+
+func (ms *MemoryStorage) Iterate(from, to uint32, fn func(*Block) error) error {
+	return iterate(ms, from, to, fn)
+}
+
+func (ms *MemoryStorage) Len() uint32 {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	return uint32(len(ms.blocks))
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"blockchain/pkg/e"
+	"blockchain/types"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func putRandomBlocks(t *testing.T, s Storage, n int) []*Block {
+	blocks := make([]*Block, 0, n)
+	for i := 0; i < n; i++ {
+		block, err := RandomBlock(int(s.Len()))
+		assert.Nil(t, err)
+		assert.Nil(t, s.Put(block))
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// testStorage is the conformance suite every Storage implementation must pass
+func testStorage(t *testing.T, newStorage func(t *testing.T) Storage) {
+	t.Run("Empty", func(t *testing.T) {
+		s := newStorage(t)
+		assert.Equal(t, uint32(0), s.Len())
+		_, err := s.Head()
+		assert.ErrorIs(t, err, e.ErrBlockUnKnown)
+		_, err = s.Get(0)
+		assert.ErrorIs(t, err, e.ErrBlockUnKnown)
+	})
+
+	t.Run("PutGet", func(t *testing.T) {
+		s := newStorage(t)
+		blocks := putRandomBlocks(t, s, 10)
+		assert.Equal(t, uint32(10), s.Len())
+
+		for i, block := range blocks {
+			got, err := s.Get(uint32(i))
+			assert.Nil(t, err)
+			assert.Equal(t, block, got)
+
+			got, err = s.GetByHash(NewBlockHasher().Hash(block.Header))
+			assert.Nil(t, err)
+			assert.Equal(t, block, got)
+
+			header, err := s.GetHeader(uint32(i))
+			assert.Nil(t, err)
+			assert.Equal(t, block.Header, header)
+		}
+		_, err := s.Get(10)
+		assert.ErrorIs(t, err, e.ErrBlockUnKnown)
+		_, err = s.GetHeader(10)
+		assert.ErrorIs(t, err, e.ErrBlockUnKnown)
+		_, err = s.GetByHash(types.Hash{})
+		assert.ErrorIs(t, err, e.ErrBlockUnKnown)
+	})
+
+	t.Run("PutOutOfOrder", func(t *testing.T) {
+		s := newStorage(t)
+		putRandomBlocks(t, s, 2)
+		block, _ := RandomBlock(5)
+		assert.NotNil(t, s.Put(block))
+		block, _ = RandomBlock(1)
+		assert.NotNil(t, s.Put(block))
+		assert.Equal(t, uint32(2), s.Len())
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		s := newStorage(t)
+		blocks := putRandomBlocks(t, s, 3)
+		head, err := s.Head()
+		assert.Nil(t, err)
+		assert.Equal(t, blocks[2].Header, head)
+	})
+
+	t.Run("GetTx", func(t *testing.T) {
+		s := newStorage(t)
+		blocks := putRandomBlocks(t, s, 5)
+		for _, block := range blocks {
+			for i, tx := range block.Transaction {
+				got, loc, err := s.GetTx(tx.Hash(TxHasher{}))
+				assert.Nil(t, err)
+				assert.Equal(t, &TxLocation{Height: block.Height, Index: i}, loc)
+				assert.Equal(t, tx.Hash(TxHasher{}), got.Hash(TxHasher{}))
+			}
+		}
+		_, _, err := s.GetTx(types.Hash{})
+		assert.ErrorIs(t, err, e.ErrTxUnKnown)
+	})
+
+	t.Run("Iterate", func(t *testing.T) {
+		s := newStorage(t)
+		putRandomBlocks(t, s, 10)
+		heights := []uint32{}
+		err := s.Iterate(3, 7, func(b *Block) error {
+			heights = append(heights, b.Height)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []uint32{3, 4, 5, 6, 7}, heights)
+
+		stop := errors.New("stop")
+		count := 0
+		err = s.Iterate(0, 9, func(b *Block) error {
+			count++
+			if b.Height == 4 {
+				return stop
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 5, count)
+
+		assert.NotNil(t, s.Iterate(5, 4, func(*Block) error { return nil }))
+		assert.ErrorIs(t, s.Iterate(0, 10, func(*Block) error { return nil }), e.ErrBlockUnKnown)
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		return NewStorage()
+	})
+}
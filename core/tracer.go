@@ -0,0 +1,173 @@
+package core
+
+import (
+	"blockchain/types"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Tracer observes a vm instruction by instruction. The hooks run
+// synchronously, a debugger can pause execution by blocking in them. Nested
+// calls report to the tracer of their caller.
+type Tracer interface {
+	// CaptureStep is called before an instruction runs
+	CaptureStep(step *Step)
+	// CaptureStepEnd is called with the same step once the instruction ran,
+	// its gas cost, storage accesses and error are filled in
+	CaptureStepEnd(step *Step)
+	// CaptureEnd is called when a vm stops, err is nil on success
+	CaptureEnd(depth int, gasUsed uint64, ret []byte, err error)
+}
+
+// Step is one instruction of a traced vm
+type Step struct {
+	// Depth is the number of calls the vm is nested in, Address the running
+	// contract in hex
+	Depth   int    `json:"depth"`
+	Address string `json:"address"`
+	IP      int    `json:"ip"`
+	Op      byte   `json:"op"`
+	OpName  string `json:"opName"`
+	// Gas is the gas left before the instruction, GasCost is what it used
+	// including the gas of a nested call
+	Gas     uint64 `json:"gas"`
+	GasCost uint64 `json:"gasCost"`
+	// Stack is the stack before the instruction bottom up, see StackString
+	Stack  []string        `json:"stack"`
+	Reads  []StorageAccess `json:"reads,omitempty"`
+	Writes []StorageAccess `json:"writes,omitempty"`
+	Err    string          `json:"error,omitempty"`
+}
+
+// StorageAccess is a contract state key read or written by an instruction,
+// the value is hex and empty for a missing key
+type StorageAccess struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// StackString formats a stack item: words in decimal, bytes as 0x prefixed
+// hex and a single byte as byte(0x..)
+func StackString(v any) string {
+	switch v := v.(type) {
+	case *big.Int:
+		return v.String()
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case byte:
+		return fmt.Sprintf("byte(%#02x)", v)
+	}
+	return fmt.Sprint(v)
+}
+
+// newStep snapshots the vm before the instruction at ip runs
+func (vm *VM) newStep(instr byte) *Step {
+	stack := make([]string, vm.stack.sp)
+	for i, v := range vm.stack.data[:vm.stack.sp] {
+		stack[i] = StackString(v)
+	}
+	name := fmt.Sprintf("%#02x", instr)
+	if info, ok := LookupOpcode(instr); ok {
+		name = info.Name
+	}
+	return &Step{
+		Depth:   vm.depth,
+		Address: hex.EncodeToString(vm.address),
+		IP:      vm.ip,
+		Op:      instr,
+		OpName:  name,
+		Gas:     vm.gasLimit - vm.gasUsed,
+		Stack:   stack,
+	}
+}
+
+// traceRead and traceWrite record a storage access of the traced step
+func (vm *VM) traceRead(key string, value []byte) {
+	if vm.traced != nil {
+		vm.traced.Reads = append(vm.traced.Reads, StorageAccess{key, hex.EncodeToString(value)})
+	}
+}
+
+func (vm *VM) traceWrite(key string, value []byte) {
+	if vm.traced != nil {
+		vm.traced.Writes = append(vm.traced.Writes, StorageAccess{key, hex.EncodeToString(value)})
+	}
+}
+
+// JSONTracer writes every executed step as one JSON object per line, followed
+// by a result line per vm
+type JSONTracer struct {
+	enc *json.Encoder
+}
+
+// TraceResult is the line written when a vm stops
+type TraceResult struct {
+	Depth       int    `json:"depth"`
+	GasUsed     uint64 `json:"gasUsed"`
+	ReturnValue string `json:"returnValue"`
+	Err         string `json:"error,omitempty"`
+}
+
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+func (t *JSONTracer) CaptureStep(step *Step) {}
+
+func (t *JSONTracer) CaptureStepEnd(step *Step) {
+	t.enc.Encode(step)
+}
+
+func (t *JSONTracer) CaptureEnd(depth int, gasUsed uint64, ret []byte, err error) {
+	res := TraceResult{Depth: depth, GasUsed: gasUsed, ReturnValue: hex.EncodeToString(ret)}
+	if err != nil {
+		res.Err = err.Error()
+	}
+	t.enc.Encode(res)
+}
+
+// TraceTx replays the included transaction hash on the state it ran on, the
+// state of the parent block after the transactions before it, and reports
+// the execution to tracer. The chain is not changed.
+func (bc *Blockchain) TraceTx(hash types.Hash, tracer Tracer) (*Receipt, error) {
+	tx, loc, err := bc.GetTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	if loc.Height == 0 {
+		return nil, fmt.Errorf("tx %s is part of the genesis block", hash)
+	}
+	b, err := bc.GetBlock(loc.Height)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := bc.stateAt(loc.Height - 1)
+	if err != nil {
+		return nil, err
+	}
+	ex, err := bc.executeBlock(parent, &Block{Header: b.Header, Transaction: b.Transaction[:loc.Index]})
+	if err != nil {
+		return nil, err
+	}
+	if err := ex.accountState.useNonce(tx); err != nil {
+		return nil, err
+	}
+	if err := ex.accountState.move(tx.From, tx.receiver(), tx.Value); err != nil {
+		return nil, err
+	}
+	receipt := &Receipt{
+		TxHash:    hash,
+		BlockHash: b.Hash(NewBlockHasher()),
+		Height:    loc.Height,
+		Index:     loc.Index,
+		Success:   true,
+	}
+	if err := applyTx(ex.contractState, ex.accountState, newBlockContext(b), tx, receipt, tracer); err != nil {
+		receipt.Success = false
+		receipt.Err = err.Error()
+	}
+	return receipt, nil
+}
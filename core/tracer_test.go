@@ -0,0 +1,156 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTracer struct {
+	steps []*Step
+	ends  []string
+}
+
+func (t *recordingTracer) CaptureStep(step *Step) {
+	// the instruction has not run yet
+	if step.GasCost != 0 || step.Err != "" {
+		panic("step captured after it ran")
+	}
+}
+
+func (t *recordingTracer) CaptureStepEnd(step *Step) {
+	t.steps = append(t.steps, step)
+}
+
+func (t *recordingTracer) CaptureEnd(depth int, gasUsed uint64, ret []byte, err error) {
+	end := hex.EncodeToString(ret)
+	if err != nil {
+		end = err.Error()
+	}
+	t.ends = append(t.ends, end)
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	// "k" 7 store, "k" get, return
+	vm, _, _ := newCallVM([]byte{0x20, 0x07, 0x2a, 0x01, 'k', 0x0f, 0x2a, 0x01, 'k', 0x10, 0x1d}, nil, testGas)
+	vm.tracer = tracer
+	assert.Nil(t, vm.run())
+
+	key := ContractStorageKey(vm.address, "k")
+	seven := hex.EncodeToString(wordBytes(newWord(7)))
+	assert.Len(t, tracer.steps, 6)
+	store := tracer.steps[2]
+	assert.Equal(t, 5, store.IP)
+	assert.Equal(t, "store", store.OpName)
+	assert.Equal(t, []string{"7", "0x6b"}, store.Stack)
+	assert.Equal(t, []StorageAccess{{key, seven}}, store.Writes)
+	assert.Equal(t, gasTable[instrStore]+wordSize*gasStoreByte, store.GasCost)
+	assert.Equal(t, testGas-gasTable[instrPush1]-gasTable[instrPushBytes], store.Gas)
+	assert.Equal(t, []StorageAccess{{key, seven}}, tracer.steps[4].Reads)
+	assert.Equal(t, []string{seven}, tracer.ends)
+
+	// a failing step reports its error
+	tracer = &recordingTracer{}
+	vm = NewVM([]byte{0x2a, 0x01, 'x', 0x10}, NewContractState(), testGas)
+	vm.tracer = tracer
+	assert.NotNil(t, vm.run())
+	assert.Equal(t, []StorageAccess{{"x", ""}}, tracer.steps[1].Reads)
+	assert.Contains(t, tracer.steps[1].Err, "x")
+	assert.Len(t, tracer.ends, 1)
+	assert.Contains(t, tracer.ends[0], tracer.steps[1].Err)
+}
+
+func TestTracerCall(t *testing.T) {
+	tracer := &recordingTracer{}
+	vm, _, addr := newCallVM(nil, calleeCode, testGas)
+	vm.data = callCode(addr, []byte("args"), 0, 1000)
+	vm.tracer = tracer
+	assert.Nil(t, vm.run())
+
+	depths := []int{}
+	for _, step := range tracer.steps {
+		depths = append(depths, step.Depth)
+	}
+	// the callee steps are reported before the call step ends
+	assert.Equal(t, []int{0, 0, 0, 0, 1, 1, 1, 1, 1, 0}, depths)
+	assert.Equal(t, hex.EncodeToString(addr), tracer.steps[4].Address)
+	assert.Equal(t, []string{hex.EncodeToString([]byte("ok")), ""}, tracer.ends)
+	assert.Equal(t, vm.gasUsed-4, tracer.steps[9].GasCost)
+}
+
+func TestJSONTracer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	vm := NewVM([]byte{0x20, 0x01, 0x20, 0x02, 0x0b, 0x1d}, NewContractState(), testGas)
+	vm.tracer = NewJSONTracer(buf)
+	assert.Nil(t, vm.run())
+
+	lines := []map[string]any{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := map[string]any{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	assert.Len(t, lines, 5)
+	assert.Equal(t, "add", lines[2]["opName"])
+	assert.Equal(t, []any{"1", "2"}, lines[2]["stack"])
+	assert.Equal(t, hex.EncodeToString(wordBytes(newWord(3))), lines[4]["returnValue"])
+}
+
+func TestTraceTx(t *testing.T) {
+	addr := crypto.PublicKey(make([]byte, ContractAddressSize))
+	genesis := DefaultGenesis()
+	genesis.Storage = map[string]string{
+		// "n" get calldata concat, dup 1 "n" store, return
+		ContractCodeKey(addr):         hex.EncodeToString([]byte{0x2a, 0x01, 'n', 0x10, 0x36, 0x28, 0x25, 0x01, 0x2a, 0x01, 'n', 0x0f, 0x1d}),
+		ContractStorageKey(addr, "n"): hex.EncodeToString([]byte("a")),
+	}
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), genesis)
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	txx := []*Transaction{}
+	for i, arg := range []string{"b", "c", "d", "e"} {
+		tx := callTx(t, pri, addr, uint64(i), testGas)
+		tx.CallData = []byte(arg)
+		if arg == "d" {
+			tx.GasLimit = 3
+		}
+		assert.Nil(t, tx.Sign(&pri))
+		txx = append(txx, tx)
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, txx[:2])))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, txx[2:])))
+
+	for _, tx := range txx {
+		tracer := &recordingTracer{}
+		receipt, err := bc.TraceTx(tx.Hash(TxHasher{}), tracer)
+		assert.Nil(t, err)
+		stored, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, stored.Success, receipt.Success)
+		assert.Equal(t, stored.Err, receipt.Err)
+		assert.Equal(t, stored.GasUsed, receipt.GasUsed)
+		assert.Equal(t, stored.ReturnValue, receipt.ReturnValue)
+		assert.Equal(t, stored.Writes, receipt.Writes)
+		assert.NotEmpty(t, tracer.steps)
+		assert.Len(t, tracer.ends, 1)
+	}
+	// the second tx sees the write of the first one in the same block
+	receipt, err := bc.TraceTx(txx[1].Hash(TxHasher{}), nil)
+	assert.Nil(t, err)
+	assert.Len(t, receipt.ReturnValue, 3)
+	value, err := bc.ContractState.get(ContractStorageKey(addr, "n"))
+	assert.Nil(t, err)
+	assert.Len(t, value, 4)
+
+	_, err = bc.TraceTx(NewTransaction(nil).Hash(TxHasher{}), nil)
+	assert.NotNil(t, err)
+}
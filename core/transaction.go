@@ -10,11 +10,16 @@ import (
 )
 
 type Transaction struct {
-	Data  []byte
-	To    crypto.PublicKey
-	From  crypto.PublicKey
-	Value uint64
-	Nonce uint64
+	// Data is the code of a deploy, a tx without To, and ignored otherwise
+	Data []byte
+	// CallData holds the arguments of a contract call
+	CallData []byte
+	To       crypto.PublicKey
+	From     crypto.PublicKey
+	Value    uint64
+	Nonce    uint64
+	// GasLimit caps the gas the vm may use running Data
+	GasLimit uint64
 
 	Signature *crypto.Signature
 	hash      types.Hash
@@ -67,6 +72,8 @@ func (t *Transaction) ToProto() *pb.Transaction {
 		Signature: t.Signature.ToProto(),
 		FirstSeen: t.FirstSeen,
 		Hash:      t.hash[:],
+		GasLimit:  t.GasLimit,
+		CallData:  t.CallData,
 	}
 }
 
@@ -80,6 +87,8 @@ func TxFromProto(proto *pb.Transaction) *Transaction {
 		Signature: crypto.FromProto(proto.Signature),
 		FirstSeen: proto.FirstSeen,
 		hash:      types.Hash(proto.Hash),
+		GasLimit:  proto.GasLimit,
+		CallData:  proto.CallData,
 	}
 	return t
 }
@@ -91,6 +100,20 @@ func (tx *Transaction) Hash(hasher TxHasher) types.Hash {
 	return hasher.Hash(tx)
 }
 
+// IsDeploy reports whether tx deploys Data as the code of a new contract
+func (tx *Transaction) IsDeploy() bool {
+	return len(tx.To) == 0 && len(tx.Data) > 0
+}
+
+// receiver is the account credited with tx.Value, a deploy pays the new
+// contract
+func (tx *Transaction) receiver() crypto.PublicKey {
+	if tx.IsDeploy() {
+		return ContractAddress(tx.From, tx.Nonce)
+	}
+	return tx.To
+}
+
 func (tx *Transaction) SetFirstSeen(t int64) {
 	tx.FirstSeen = t
 }
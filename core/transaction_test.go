@@ -23,6 +23,25 @@ func TestTxWrongData(t *testing.T) {
 	assert.NotNil(t, tx.Verify())
 }
 
+func TestTxHashFieldBoundaries(t *testing.T) {
+	pri := crypto.GenerateKeyPair()
+	call := NewTransaction(nil)
+	call.To = ContractAddress(pri.PublicKey(), 0)
+	assert.Nil(t, call.Sign(&pri))
+
+	// the same bytes as the code of a deploy
+	deploy := *call
+	deploy.Data, deploy.To = call.To, nil
+	assert.True(t, deploy.IsDeploy())
+	assert.NotEqual(t, call.Hash(TxHasher{}), deploy.Hash(TxHasher{}))
+	assert.NotNil(t, deploy.Verify())
+
+	// so does moving a byte from To to Data
+	moved := *call
+	moved.Data, moved.To = call.To[len(call.To)-1:], call.To[:len(call.To)-1]
+	assert.NotEqual(t, call.Hash(TxHasher{}), moved.Hash(TxHasher{}))
+}
+
 func TestTxWrongFrom(t *testing.T) {
 	tx := RandomTxWithSignature()
 	assert.Nil(t, tx.Verify())
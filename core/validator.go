@@ -2,11 +2,15 @@ package core
 
 import (
 	"blockchain/pkg/e"
+	"blockchain/types"
 	"fmt"
 )
 
 type Validator interface {
 	ValidateBlock(*Block) error
+	// ValidateState checks the block against the state root and logs bloom its
+	// execution reached
+	ValidateState(b *Block, root types.Hash, bloom Bloom) error
 }
 
 type BlockValidator struct {
@@ -36,8 +40,28 @@ func (bv *BlockValidator) ValidateBlock(b *Block) error {
 	if prehash != b.PrevBlock {
 		return fmt.Errorf("invalid prev block hash: %s, expected: %s", b.PrevBlock, prehash)
 	}
+	// contracts read the time, a validator must not move it back
+	if b.TimeStamp <= preHeader.TimeStamp {
+		return fmt.Errorf("invalid block timestamp: %d, parent: %d", b.TimeStamp, preHeader.TimeStamp)
+	}
+	if gas, limit := TotalGasLimit(b.Transaction), bv.Bc.Genesis.BlockGasLimit(); gas > limit {
+		return fmt.Errorf("block gas limit exceeded: %d, limit: %d", gas, limit)
+	}
+	if !bv.Bc.Genesis.IsValidator(b.Validator) {
+		return fmt.Errorf("block signer %s is not a validator", b.Validator)
+	}
 	if err := b.Verify(); err != nil {
 		return err
 	}
 	return nil
 }
+
+func (bv *BlockValidator) ValidateState(b *Block, root types.Hash, bloom Bloom) error {
+	if b.StateRoot != root {
+		return fmt.Errorf("invalid state root: %s, expected: %s", b.StateRoot, root)
+	}
+	if b.LogsBloom != bloom {
+		return fmt.Errorf("invalid logs bloom: %s, expected: %s", b.LogsBloom, bloom)
+	}
+	return nil
+}
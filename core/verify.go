@@ -0,0 +1,238 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrStackMismatch is returned when the paths into an instruction leave
+	// the stack at different depths, a loop that grows the stack has no bound
+	ErrStackMismatch = errors.New("stack depth differs between paths")
+	// ErrDynamicOperand is returned for a jump or pack whose operand is not
+	// pushed right before it
+	ErrDynamicOperand = errors.New("operand is not a constant")
+)
+
+// stackEffects are the items an instruction pops and pushes, instrDup,
+// instrSwap and instrPack depend on their operand
+var stackEffects = map[byte][2]int{
+	instrAdd:          {2, 1},
+	instrMinus:        {2, 1},
+	instrMult:         {2, 1},
+	instrDiv:          {2, 1},
+	instrMod:          {2, 1},
+	instrSdiv:         {2, 1},
+	instrSmod:         {2, 1},
+	instrExp:          {2, 1},
+	instrShl:          {2, 1},
+	instrShr:          {2, 1},
+	instrSar:          {2, 1},
+	instrEq:           {2, 1},
+	instrLt:           {2, 1},
+	instrGt:           {2, 1},
+	instrSlt:          {2, 1},
+	instrSgt:          {2, 1},
+	instrAnd:          {2, 1},
+	instrOr:           {2, 1},
+	instrNot:          {1, 1},
+	instrConcat:       {2, 1},
+	instrStore:        {2, 0},
+	instrGet:          {1, 1},
+	instrJump:         {1, 0},
+	instrJumpi:        {2, 0},
+	instrJumpdest:     {0, 0},
+	instrHalt:         {0, 0},
+	instrReturn:       {1, 0},
+	instrPush1:        {0, 1},
+	instrPush2:        {0, 1},
+	instrPush4:        {0, 1},
+	instrPush8:        {0, 1},
+	instrPush32:       {0, 1},
+	instrPushByte1:    {0, 1},
+	instrPushBytes:    {0, 1},
+	instrPop:          {1, 0},
+	instrLog:          {2, 0},
+	instrCall:         {4, 2},
+	instrCallData:     {0, 1},
+	instrCallDataSize: {0, 1},
+	instrCallDataLoad: {1, 1},
+	instrCaller:       {0, 1},
+	instrCallValue:    {0, 1},
+	instrHeight:       {0, 1},
+	instrTimestamp:    {0, 1},
+	instrValidator:    {0, 1},
+	instrSelfBalance:  {0, 1},
+}
+
+// VerifyError is returned by VerifyCode, Err is one of the vm errors or
+// the verifier errors above
+type VerifyError struct {
+	Offset int
+	Op     byte
+	Err    error
+}
+
+func (err *VerifyError) Error() string {
+	return fmt.Sprintf("verify: %s at offset %d (opcode 0x%02x)", err.Err, err.Offset, err.Op)
+}
+
+func (err *VerifyError) Unwrap() error {
+	return err.Err
+}
+
+// verifier follows every path through code from the first instruction and
+// tracks the stack depth
+type verifier struct {
+	code []byte
+	// prev is the offset of the instruction before each instruction, -1 for
+	// the first one
+	prev  []int
+	dests []bool
+	// depths is the stack depth before each reached instruction, -1 until
+	// it is reached
+	depths []int
+}
+
+// VerifyCode checks code before it is deployed: every opcode is known and
+// has its complete immediate, every jump goes to a jumpdest pushed right
+// before it, and the stack neither underflows nor grows past the vm stack on
+// any path. Each instruction has to be reached with the same stack depth on
+// every path, which bounds the depth of loops.
+func VerifyCode(code []byte) error {
+	v := &verifier{
+		code:   code,
+		prev:   make([]int, len(code)),
+		dests:  jumpdests(code),
+		depths: make([]int, len(code)),
+	}
+	prev := -1
+	for ip := 0; ip < len(code); ip += InstrSize(code, ip) {
+		if _, ok := opcodes[code[ip]]; !ok {
+			return &VerifyError{ip, code[ip], ErrUnknownOpcode}
+		}
+		if ip+InstrSize(code, ip) > len(code) {
+			return &VerifyError{ip, code[ip], ErrTruncatedCode}
+		}
+		v.prev[ip] = prev
+		prev = ip
+	}
+	for i := range v.depths {
+		v.depths[i] = -1
+	}
+	if len(code) == 0 {
+		return nil
+	}
+	v.depths[0] = 0
+	work := []int{0}
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		next, err := v.step(ip)
+		if err != nil {
+			return &VerifyError{ip, code[ip], err}
+		}
+		depth := v.depths[ip] + v.effect(ip)
+		for _, n := range next {
+			if n >= len(code) {
+				// running off the end stops the vm
+				continue
+			}
+			if v.depths[n] == -1 {
+				v.depths[n] = depth
+				work = append(work, n)
+			} else if v.depths[n] != depth {
+				return &VerifyError{n, code[n], fmt.Errorf("%w: %d and %d", ErrStackMismatch, v.depths[n], depth)}
+			}
+		}
+	}
+	return nil
+}
+
+// constant returns the word pushed by the instruction before ip
+func (v *verifier) constant(ip int) (*big.Int, bool) {
+	prev := v.prev[ip]
+	if prev < 0 {
+		return nil, false
+	}
+	switch v.code[prev] {
+	case instrPush1, instrPush2, instrPush4, instrPush8, instrPush32:
+		return new(big.Int).SetBytes(v.code[prev+1 : prev+InstrSize(v.code, prev)]), true
+	}
+	return nil, false
+}
+
+// pops returns the items the instruction at ip pops and needs on the stack
+func (v *verifier) pops(ip int) (int, error) {
+	switch v.code[ip] {
+	case instrDup:
+		n := int(v.code[ip+1])
+		if n == 0 {
+			return 0, ErrStackUnderflow
+		}
+		return n, nil
+	case instrSwap:
+		return int(v.code[ip+1]) + 1, nil
+	case instrPack:
+		w, ok := v.constant(ip)
+		if !ok {
+			return 0, fmt.Errorf("%w: pack count", ErrDynamicOperand)
+		}
+		n, ok := wordToInt(w, stackSize)
+		if !ok {
+			return 0, fmt.Errorf("%w: pack %s bytes", ErrStackUnderflow, w)
+		}
+		return n + 1, nil
+	}
+	return stackEffects[v.code[ip]][0], nil
+}
+
+// effect is the change of the stack depth by the instruction at ip, which
+// step already checked
+func (v *verifier) effect(ip int) int {
+	pops, _ := v.pops(ip)
+	switch v.code[ip] {
+	case instrDup:
+		return 1
+	case instrSwap:
+		return 0
+	case instrPack:
+		return 1 - pops
+	}
+	return stackEffects[v.code[ip]][1] - pops
+}
+
+// step checks the instruction at ip and returns the offsets it continues at
+func (v *verifier) step(ip int) ([]int, error) {
+	depth := v.depths[ip]
+	pops, err := v.pops(ip)
+	if err != nil {
+		return nil, err
+	}
+	if pops > depth {
+		return nil, fmt.Errorf("%w: needs %d items, has %d", ErrStackUnderflow, pops, depth)
+	}
+	if depth+v.effect(ip) > stackSize {
+		return nil, ErrStackOverflow
+	}
+	next := ip + InstrSize(v.code, ip)
+	switch v.code[ip] {
+	case instrHalt, instrReturn:
+		return nil, nil
+	case instrJump, instrJumpi:
+		dest, ok := v.constant(ip)
+		if !ok {
+			return nil, fmt.Errorf("%w: jump destination", ErrDynamicOperand)
+		}
+		target, ok := wordToInt(dest, len(v.code)-1)
+		if !ok || !v.dests[target] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidJump, dest)
+		}
+		if v.code[ip] == instrJump {
+			return []int{target}, nil
+		}
+		return []int{target, next}, nil
+	}
+	return []int{next}, nil
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"blockchain/crypto"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCode(t *testing.T) {
+	for name, code := range map[string][]byte{
+		"empty":         nil,
+		"store program": storeProgram,
+		"call":          callCode(make([]byte, ContractAddressSize), []byte("args"), 0, 1000),
+		// 0 8 jumpi, push and pop on one path only
+		"branch": {0x20, 0x00, 0x20, 0x08, 0x1a, 0x20, 0x01, 0x27, 0x1b, 0x1c},
+		// i = 3; loop: i - 1, dup, jumpi loop
+		"loop": {0x20, 0x03, 0x1b, 0x20, 0x01, 0x26, 0x01, 0x0e, 0x25, 0x01, 0x20, 0x02, 0x1a, 0x1c},
+		// unreachable code after halt is not followed
+		"dead code": {0x1c, 0x27, 0x27},
+		"pack":      {0x29, 'a', 0x29, 'b', 0x20, 0x02, 0x0d, 0x1d},
+		"calldata":  {0x37, 0x38, 0x36, 0x28, 0x27},
+	} {
+		assert.Nil(t, VerifyCode(code), name)
+	}
+
+	for name, tc := range map[string]struct {
+		code   []byte
+		offset int
+		err    error
+	}{
+		"unknown opcode":       {[]byte{0x20, 0x01, 0xff}, 2, ErrUnknownOpcode},
+		"unknown dead opcode":  {[]byte{0x1c, 0xff}, 1, ErrUnknownOpcode},
+		"truncated push":       {[]byte{0x21, 0x01}, 0, ErrTruncatedCode},
+		"truncated push bytes": {[]byte{0x2a, 0x03, 'a'}, 0, ErrTruncatedCode},
+		"underflow":            {[]byte{0x20, 0x01, 0x0b}, 2, ErrStackUnderflow},
+		"dup zero":             {[]byte{0x20, 0x01, 0x25, 0x00}, 2, ErrStackUnderflow},
+		"swap too deep":        {[]byte{0x20, 0x01, 0x26, 0x01}, 2, ErrStackUnderflow},
+		"pack too many":        {[]byte{0x29, 'a', 0x20, 0x02, 0x0d}, 4, ErrStackUnderflow},
+		"dynamic pack":         {[]byte{0x29, 'a', 0x20, 0x01, 0x25, 0x01, 0x0d}, 6, ErrDynamicOperand},
+		"dynamic jump":         {[]byte{0x1b, 0x20, 0x00, 0x25, 0x01, 0x19}, 5, ErrDynamicOperand},
+		"jump into immediate":  {[]byte{0x20, 0x03, 0x19, 0x20, 0x1b}, 2, ErrInvalidJump},
+		"jump past the end":    {[]byte{0x20, 0x09, 0x19}, 2, ErrInvalidJump},
+		"jump to non dest":     {[]byte{0x20, 0x00, 0x19}, 2, ErrInvalidJump},
+		// the loop pushes 1 every iteration
+		"growing loop": {[]byte{0x1b, 0x20, 0x01, 0x20, 0x00, 0x19}, 0, ErrStackMismatch},
+		// one branch pushes an extra item
+		"unbalanced branch": {[]byte{0x20, 0x00, 0x20, 0x07, 0x1a, 0x20, 0x01, 0x1b, 0x1c}, 7, ErrStackMismatch},
+	} {
+		err := VerifyCode(tc.code)
+		assert.ErrorIs(t, err, tc.err, name)
+		var verifyErr *VerifyError
+		if assert.ErrorAs(t, err, &verifyErr, name) {
+			assert.Equal(t, tc.offset, verifyErr.Offset, name)
+		}
+	}
+
+	// pushing past the stack size overflows
+	code := []byte{}
+	for i := 0; i <= stackSize; i++ {
+		code = append(code, 0x20, 0x01)
+	}
+	assert.ErrorIs(t, VerifyCode(code), ErrStackOverflow)
+}
+
+// code that passes VerifyCode never fails at runtime with an error the
+// verifier rules out
+func FuzzVerifyCode(f *testing.F) {
+	f.Add(storeProgram)
+	f.Add([]byte{0x20, 0x03, 0x1b, 0x20, 0x01, 0x26, 0x01, 0x0e, 0x25, 0x01, 0x20, 0x02, 0x1a, 0x1c})
+	f.Add([]byte{0x29, 'a', 0x29, 'b', 0x20, 0x02, 0x0d, 0x1d})
+	f.Fuzz(func(t *testing.T, code []byte) {
+		if VerifyCode(code) != nil {
+			return
+		}
+		err := NewVM(code, NewContractState(), testGas).run()
+		for _, ruledOut := range []error{ErrUnknownOpcode, ErrTruncatedCode, ErrInvalidJump, ErrStackUnderflow, ErrStackOverflow} {
+			assert.NotErrorIs(t, err, ruledOut)
+		}
+	})
+}
+
+func TestDeployVerifiesCode(t *testing.T) {
+	bc, err := NewBlockChain(log.NewNopLogger(), NewStorage(), DefaultGenesis())
+	assert.Nil(t, err)
+
+	pri := crypto.GenerateKeyPair()
+	deploy := deployTx(t, pri, []byte{0x20, 0x01, 0x19}, 0, testGas)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, []*Transaction{deploy})))
+
+	receipt, err := bc.GetReceipt(deploy.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Success)
+	assert.Contains(t, receipt.Err, ErrInvalidJump.Error())
+	// the deploy pays for the code it tried to store
+	assert.Equal(t, gasDeploy+3*gasStoreByte, receipt.GasUsed)
+	_, err = bc.GetCode(ContractAddress(pri.PublicKey(), 0))
+	assert.NotNil(t, err)
+}
@@ -1,24 +1,127 @@
 package core
 
 import (
-	"blockchain/pkg/utils/tool"
+	"blockchain/crypto"
+	"blockchain/pkg/e"
+	"bytes"
+	"errors"
 	"fmt"
+	"math/big"
 )
 
 type Instruction byte
 
 const (
-	instrPushInt  = 0x0a // push to stackt
+	// instrPushInt and instrPushByte are the legacy pushes that took their
+	// operand from the byte before them, the vm no longer runs them and
+	// DecodeLegacy rewrites them to instrPush1 and instrPushByte1
+	instrPushInt  = 0x0a
 	instrAdd      = 0x0b // 10 represent add
-	instrPushByte = 0x0c // deal with char
-	instrPack     = 0x0d // ?
+	instrPushByte = 0x0c
+	instrPack     = 0x0d // pop n, then pack n bytes into a []byte
 	instrMinus    = 0x0e
 	instrStore    = 0x0f // store to state
 	instrGet      = 0x10
 	instrMult     = 0x11
 	instrDiv      = 0x12
+	// comparisons and logic push 1 for true and 0 for false, any non zero word
+	// counts as true
+	instrEq  = 0x13
+	instrLt  = 0x14
+	instrGt  = 0x15
+	instrAnd = 0x16
+	instrOr  = 0x17
+	instrNot = 0x18
+	// jumps pop the destination, which has to be an instrJumpdest
+	instrJump     = 0x19
+	instrJumpi    = 0x1a // jump if the word below the destination is non zero
+	instrJumpdest = 0x1b
+	instrHalt     = 0x1c
+	instrReturn   = 0x1d // pop the return value and halt
+	// pushes read a big endian word of the given width after the opcode
+	instrPush1  = 0x20
+	instrPush2  = 0x21
+	instrPush4  = 0x22
+	instrPush8  = 0x23
+	instrPush32 = 0x24
+	instrDup    = 0x25 // dup n copies the n-th item, dup 1 is the top
+	instrSwap   = 0x26 // swap n swaps the top with the item n below it
+	instrPop    = 0x27
+	instrConcat = 0x28 // pop b, pop a, push a ++ b
+	// instrPushByte1 pushes the byte after it as a byte for instrPack
+	instrPushByte1 = 0x29
+	// instrPushBytes reads a length byte and pushes that many bytes as []byte
+	instrPushBytes = 0x2a
+	instrMod       = 0x2b
+	// signed variants read words as two's complement
+	instrSdiv = 0x2c
+	instrSmod = 0x2d
+	instrSlt  = 0x2e
+	instrSgt  = 0x2f
+	instrExp  = 0x30
+	// shifts move the top word by the word below it, sar keeps the sign
+	instrShl = 0x31
+	instrShr = 0x32
+	instrSar = 0x33
+	// instrLog pops a topic and the data below it and records them as a Log
+	instrLog = 0x34
+	// instrCall pops the callee address, the gas it may use, the value it is
+	// sent and the argument bytes, see VM.call
+	instrCall = 0x35
+	// instrCallData pushes the call data as []byte, instrCallDataSize its
+	// length and instrCallDataLoad pops an offset and pushes the 32 byte word
+	// of call data there, zero padded past the end
+	instrCallData     = 0x36
+	instrCallDataSize = 0x37
+	instrCallDataLoad = 0x38
+	// environment instructions push the context of the running call.
+	// instrCaller pushes the address that sent the call as []byte, the tx
+	// sender for the outermost call, and instrCallValue the value it sent.
+	instrCaller      = 0x39
+	instrCallValue   = 0x3a
+	instrHeight      = 0x3b
+	instrTimestamp   = 0x3c // the block time in Unix nanoseconds
+	instrValidator   = 0x3d // the public key of the block validator as []byte
+	instrSelfBalance = 0x3e
 )
 
+// binary instructions take the top of the stack as their left operand, so
+// "1 push 3 push minus" is 3 - 1
+
+const (
+	stackSize = 1024
+	// defaultStepLimit bounds the instructions one run executes, on top of gas
+	defaultStepLimit = 1 << 20
+)
+
+var (
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackOverflow  = errors.New("stack overflow")
+	ErrBadOperand     = errors.New("bad operand type")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrTruncatedCode  = errors.New("truncated bytecode")
+	ErrUnknownOpcode  = errors.New("unknown opcode")
+	ErrOutOfGas       = errors.New("out of gas")
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	ErrStepLimit      = errors.New("step limit reached")
+)
+
+// ExecutionError is returned for every failed instruction, Err is one of the
+// vm errors above
+type ExecutionError struct {
+	IP  int
+	Op  byte
+	Err error
+}
+
+func (err *ExecutionError) Error() string {
+	return fmt.Sprintf("vm: %s at ip %d (opcode 0x%02x)", err.Err, err.IP, err.Op)
+}
+
+func (err *ExecutionError) Unwrap() error {
+	return err.Err
+}
+
 type Stack struct {
 	data []any
 	sp   int
@@ -31,21 +134,77 @@ func NewStack(size int) *Stack {
 	}
 }
 
-func (s *Stack) push(v any) {
-	// s.data = append([]any{v}, s.data)
-	// s.sp++
+func (s *Stack) push(v any) error {
+	if s.sp == len(s.data) {
+		return ErrStackOverflow
+	}
 	s.data[s.sp] = v
 	s.sp++
+	return nil
 }
 
-func (s *Stack) pop() any {
+func (s *Stack) pop() (any, error) {
+	if s.sp == 0 {
+		return nil, ErrStackUnderflow
+	}
 	s.sp--
 	value := s.data[s.sp]
 	s.data[s.sp] = nil
-	// value := s.data[0]
-	// slices.Delete(s.data, 1, 1)
-	// s.sp--
-	return value
+	return value, nil
+}
+
+// dup pushes a copy of the n-th item from the top, n starts at 1
+func (s *Stack) dup(n int) error {
+	if n < 1 || n > s.sp {
+		return fmt.Errorf("%w: dup %d of %d items", ErrStackUnderflow, n, s.sp)
+	}
+	return s.push(s.data[s.sp-n])
+}
+
+// swap exchanges the top with the item n below it, n starts at 1
+func (s *Stack) swap(n int) error {
+	if n < 1 || n >= s.sp {
+		return fmt.Errorf("%w: swap %d of %d items", ErrStackUnderflow, n, s.sp)
+	}
+	top := s.sp - 1
+	s.data[top], s.data[top-n] = s.data[top-n], s.data[top]
+	return nil
+}
+
+func (s *Stack) popWord() (*big.Int, error) {
+	v, err := s.pop()
+	if err != nil {
+		return nil, err
+	}
+	w, ok := v.(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected word, got %T", ErrBadOperand, v)
+	}
+	return w, nil
+}
+
+func (s *Stack) popByte() (byte, error) {
+	v, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	b, ok := v.(byte)
+	if !ok {
+		return 0, fmt.Errorf("%w: expected byte, got %T", ErrBadOperand, v)
+	}
+	return b, nil
+}
+
+func (s *Stack) popBytes() ([]byte, error) {
+	v, err := s.pop()
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected bytes, got %T", ErrBadOperand, v)
+	}
+	return b, nil
 }
 
 type VM struct {
@@ -53,91 +212,478 @@ type VM struct {
 	data          []byte
 	ip            int // instruction pointer
 	contractstate *contractState
+	accounts      *accountState
+	// address of the running contract, it pays the value of instrCall
+	address crypto.PublicKey
+	// depth is the number of calls the vm is nested in
+	depth int
+	// input is the call data
+	input []byte
+	// caller sent the running call together with value
+	caller crypto.PublicKey
+	value  uint64
+	// block is the block the vm runs in
+	block blockContext
+	// namespace prefixes the keys of instrStore and instrGet, it keeps the
+	// storage of contracts apart
+	namespace string
+	// keys written by instrStore, reported in the receipt
+	writes    []string
+	gasLimit  uint64
+	gasUsed   uint64
+	steps     int
+	stepLimit int
+	halted    bool
+	// next is the ip of the instruction after the one executing
+	next      int
+	jumpdests []bool
+	// ret is the value popped by instrReturn
+	ret []byte
+	// logs emitted by instrLog, in order
+	logs []*Log
+	// tracer is optional, traced is the step it is shown while an
+	// instruction runs
+	tracer Tracer
+	traced *Step
 }
 
-func NewVM(data []byte, contractState *contractState) *VM {
+func NewVM(data []byte, contractState *contractState, gasLimit uint64) *VM {
 	return &VM{
-		stack:         NewStack(1024),
+		stack:         NewStack(stackSize),
 		data:          data,
 		ip:            0,
 		contractstate: contractState,
+		accounts:      NewAccountState(nil),
+		gasLimit:      gasLimit,
+		stepLimit:     defaultStepLimit,
 	}
 }
 
+// useGas charges gas, running out uses up the whole limit
+func (vm *VM) useGas(gas uint64) error {
+	if gas > vm.gasLimit-vm.gasUsed {
+		vm.gasUsed = vm.gasLimit
+		return ErrOutOfGas
+	}
+	vm.gasUsed += gas
+	return nil
+}
+
+// immediateSize returns the number of operand bytes following the
+// instruction at ip, it may run past the end of truncated code
+func immediateSize(data []byte, ip int) int {
+	switch data[ip] {
+	case instrPush1, instrDup, instrSwap, instrPushByte1:
+		return 1
+	case instrPush2:
+		return 2
+	case instrPush4:
+		return 4
+	case instrPush8:
+		return 8
+	case instrPush32:
+		return 32
+	case instrPushBytes:
+		if ip+1 < len(data) {
+			return 1 + int(data[ip+1])
+		}
+		return 1
+	}
+	return 0
+}
+
+// jumpdests marks every instrJumpdest that is an instruction and not part of
+// an immediate
+func jumpdests(data []byte) []bool {
+	dests := make([]bool, len(data))
+	for ip := 0; ip < len(data); ip += 1 + immediateSize(data, ip) {
+		dests[ip] = data[ip] == instrJumpdest
+	}
+	return dests
+}
+
 func (vm *VM) run() error {
-	for {
-		instr := vm.data[vm.ip]
-		err := vm.parseInstr(instr)
-		vm.ip++
+	err := vm.execute()
+	if vm.tracer != nil {
+		vm.tracer.CaptureEnd(vm.depth, vm.gasUsed, vm.ret, err)
+	}
+	return err
+}
 
-		if err != nil {
-			return err
+func (vm *VM) execute() error {
+	if vm.jumpdests == nil {
+		vm.jumpdests = jumpdests(vm.data)
+	}
+	for vm.ip < len(vm.data) && !vm.halted {
+		instr := vm.data[vm.ip]
+		vm.next = vm.ip + 1 + immediateSize(vm.data, vm.ip)
+		if vm.tracer != nil {
+			vm.traced = vm.newStep(instr)
+			vm.tracer.CaptureStep(vm.traced)
 		}
-		if vm.ip > len(vm.data)-1 {
-			break
+		gasUsed := vm.gasUsed
+		err := vm.step(instr)
+		if vm.traced != nil {
+			vm.traced.GasCost = vm.gasUsed - gasUsed
+			if err != nil {
+				vm.traced.Err = err.Error()
+			}
+			vm.tracer.CaptureStepEnd(vm.traced)
+			vm.traced = nil
 		}
+		if err != nil {
+			return &ExecutionError{IP: vm.ip, Op: instr, Err: err}
+		}
+		vm.ip = vm.next
 	}
 	return nil
 }
 
-func (vm *VM) parseInstr(instr byte) error {
+func (vm *VM) step(instr byte) error {
+	if vm.steps == vm.stepLimit {
+		return ErrStepLimit
+	}
+	vm.steps++
+	if err := vm.useGas(gasTable[instr]); err != nil {
+		return err
+	}
+	return vm.parseInstr(instr)
+}
+
+// jump continues execution at dest
+func (vm *VM) jump(dest *big.Int) error {
+	ip, ok := wordToInt(dest, len(vm.data)-1)
+	if !ok || !vm.jumpdests[ip] {
+		return fmt.Errorf("%w: %s", ErrInvalidJump, dest)
+	}
+	vm.next = ip
+	return nil
+}
+
+// arith computes a op b, the result is wrapped by the caller
+func (vm *VM) arith(instr byte, a, b *big.Int) (*big.Int, error) {
+	res := new(big.Int)
 	switch instr {
-	case instrPushInt:
-		value := int(vm.data[vm.ip-1])
-		vm.stack.push(value)
 	case instrAdd:
-		add1 := vm.stack.pop().(int)
-		add2 := vm.stack.pop().(int)
-		res := add1 + add2
-		vm.stack.push(res)
-	case instrPushByte:
-		b := byte(vm.data[vm.ip-1])
-		vm.stack.push(b)
+		return res.Add(a, b), nil
+	case instrMinus:
+		return res.Sub(a, b), nil
+	case instrMult:
+		return res.Mul(a, b), nil
+	case instrExp:
+		if err := vm.useGas(uint64((b.BitLen()+7)/8) * gasExpByte); err != nil {
+			return nil, err
+		}
+		return res.Exp(a, b, wordModulus), nil
+	case instrShl, instrShr, instrSar:
+		// shifting by 256 or more shifts everything out
+		shift, ok := wordToInt(b, 8*wordSize)
+		if !ok {
+			shift = 8 * wordSize
+		}
+		switch instr {
+		case instrShl:
+			return res.Lsh(a, uint(shift)), nil
+		case instrShr:
+			return res.Rsh(a, uint(shift)), nil
+		default:
+			// big.Int shifts negative values arithmetically
+			return res.Rsh(toSigned(a), uint(shift)), nil
+		}
+	}
+	if b.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+	switch instr {
+	case instrDiv:
+		return res.Div(a, b), nil
+	case instrMod:
+		return res.Mod(a, b), nil
+	case instrSdiv:
+		// truncates toward zero, -2^255 / -1 wraps back to -2^255
+		return res.Quo(toSigned(a), toSigned(b)), nil
+	case instrSmod:
+		// the result takes the sign of a
+		return res.Rem(toSigned(a), toSigned(b)), nil
+	}
+	return nil, ErrUnknownOpcode
+}
+
+// immediate returns the n operand bytes of the instruction at ip
+func (vm *VM) immediate(n int) ([]byte, error) {
+	start := vm.ip + 1
+	if start+n > len(vm.data) {
+		return nil, ErrTruncatedCode
+	}
+	return vm.data[start : start+n], nil
+}
+
+func (vm *VM) parseInstr(instr byte) error {
+	switch instr {
+	case instrPush1, instrPush2, instrPush4, instrPush8, instrPush32:
+		imm, err := vm.immediate(immediateSize(vm.data, vm.ip))
+		if err != nil {
+			return err
+		}
+		return vm.stack.push(wordFromBytes(imm))
+	case instrPushByte1:
+		imm, err := vm.immediate(1)
+		if err != nil {
+			return err
+		}
+		return vm.stack.push(imm[0])
+	case instrPushBytes:
+		n, err := vm.immediate(1)
+		if err != nil {
+			return err
+		}
+		imm, err := vm.immediate(1 + int(n[0]))
+		if err != nil {
+			return err
+		}
+		return vm.stack.push(append([]byte{}, imm[1:]...))
+	case instrDup, instrSwap:
+		imm, err := vm.immediate(1)
+		if err != nil {
+			return err
+		}
+		n := int(imm[0])
+		if instr == instrDup {
+			return vm.stack.dup(n)
+		}
+		return vm.stack.swap(n)
+	case instrPop:
+		_, err := vm.stack.pop()
+		return err
+	case instrConcat:
+		b, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+		a, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+		if err := vm.useGas(uint64(len(a)+len(b)) * gasConcatByte); err != nil {
+			return err
+		}
+		res := make([]byte, 0, len(a)+len(b))
+		res = append(res, a...)
+		return vm.stack.push(append(res, b...))
+	case instrAdd, instrMinus, instrMult, instrDiv, instrMod, instrSdiv, instrSmod, instrExp, instrShl, instrShr, instrSar:
+		a, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		b, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		res, err := vm.arith(instr, a, b)
+		if err != nil {
+			return err
+		}
+		return vm.stack.push(wrap(res))
+	case instrEq:
+		a, err := vm.stack.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.stack.pop()
+		if err != nil {
+			return err
+		}
+		// words compare by value, byte strings by content
+		switch a := a.(type) {
+		case *big.Int:
+			if b, ok := b.(*big.Int); ok {
+				return vm.stack.push(wordBool(a.Cmp(b) == 0))
+			}
+		case []byte:
+			if b, ok := b.([]byte); ok {
+				return vm.stack.push(wordBool(bytes.Equal(a, b)))
+			}
+		}
+		return fmt.Errorf("%w: can not compare %T and %T", ErrBadOperand, a, b)
+	case instrLt, instrGt, instrSlt, instrSgt, instrAnd, instrOr:
+		a, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		b, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		var res bool
+		switch instr {
+		case instrLt:
+			res = a.Cmp(b) < 0
+		case instrGt:
+			res = a.Cmp(b) > 0
+		case instrSlt:
+			res = toSigned(a).Cmp(toSigned(b)) < 0
+		case instrSgt:
+			res = toSigned(a).Cmp(toSigned(b)) > 0
+		case instrAnd:
+			res = a.Sign() != 0 && b.Sign() != 0
+		case instrOr:
+			res = a.Sign() != 0 || b.Sign() != 0
+		}
+		return vm.stack.push(wordBool(res))
+	case instrNot:
+		a, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		return vm.stack.push(wordBool(a.Sign() == 0))
+	case instrJump:
+		dest, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		return vm.jump(dest)
+	case instrJumpi:
+		dest, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		cond, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		if cond.Sign() == 0 {
+			return nil
+		}
+		return vm.jump(dest)
+	case instrJumpdest:
+	case instrHalt:
+		vm.halted = true
+	case instrLog:
+		topic, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+		v, err := vm.stack.pop()
+		if err != nil {
+			return err
+		}
+		var data []byte
+		switch v := v.(type) {
+		case *big.Int:
+			data = wordBytes(v)
+		case []byte:
+			data = v
+		default:
+			return fmt.Errorf("%w: can not log %T", ErrBadOperand, v)
+		}
+		if err := vm.useGas(uint64(len(topic)+len(data)) * gasLogByte); err != nil {
+			return err
+		}
+		vm.logs = append(vm.logs, &Log{Address: vm.address, Topic: topic, Data: data})
+	case instrCall:
+		return vm.call()
+	case instrCallData:
+		if err := vm.useGas(uint64(len(vm.input)) * gasCallDataByte); err != nil {
+			return err
+		}
+		return vm.stack.push(append([]byte{}, vm.input...))
+	case instrCallDataSize:
+		return vm.stack.push(newWord(uint64(len(vm.input))))
+	case instrCallDataLoad:
+		offset, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		word := make([]byte, wordSize)
+		if start, ok := wordToInt(offset, len(vm.input)); ok {
+			copy(word, vm.input[start:])
+		}
+		return vm.stack.push(wordFromBytes(word))
+	case instrCaller:
+		return vm.stack.push(append([]byte{}, vm.caller...))
+	case instrCallValue:
+		return vm.stack.push(newWord(vm.value))
+	case instrHeight:
+		return vm.stack.push(newWord(uint64(vm.block.height)))
+	case instrTimestamp:
+		return vm.stack.push(wrap(big.NewInt(vm.block.timestamp)))
+	case instrValidator:
+		return vm.stack.push(append([]byte{}, vm.block.validator...))
+	case instrSelfBalance:
+		return vm.stack.push(newWord(vm.accounts.Balance(vm.address)))
+	case instrReturn:
+		v, err := vm.stack.pop()
+		if err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case *big.Int:
+			vm.ret = wordBytes(v)
+		case []byte:
+			vm.ret = v
+		case byte:
+			vm.ret = []byte{v}
+		}
+		vm.halted = true
 	case instrPack:
-		n := vm.stack.pop().(int)
+		w, err := vm.stack.popWord()
+		if err != nil {
+			return err
+		}
+		n, ok := wordToInt(w, vm.stack.sp)
+		if !ok {
+			return fmt.Errorf("%w: pack %s bytes from %d stack items", ErrStackUnderflow, w, vm.stack.sp)
+		}
+		if err := vm.useGas(uint64(n) * gasPackByte); err != nil {
+			return err
+		}
 		str := make([]byte, n)
 		for i := 0; i < n; i++ {
-			str[i] = vm.stack.pop().(byte)
+			if str[i], err = vm.stack.popByte(); err != nil {
+				return err
+			}
 		}
 		// push a []byte can be convert to stirng
-		vm.stack.push(str)
-	case instrMinus:
-		add1 := vm.stack.pop().(int)
-		add2 := vm.stack.pop().(int)
-		res := add1 - add2
-		vm.stack.push(res)
-	case instrMult:
-		add1 := vm.stack.pop().(int)
-		add2 := vm.stack.pop().(int)
-		res := add1 * add2
-		vm.stack.push(res)
-	case instrDiv:
-		div1 := vm.stack.pop().(int)
-		div2 := vm.stack.pop().(int)
-		res := div1 / div2
-		vm.stack.push(res)
+		return vm.stack.push(str)
 	case instrStore:
-		key := vm.stack.pop().([]byte)
+		key, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
 		//  key should be data , but value is unknown
-		data := vm.stack.pop()
-		res := []byte{}
+		data, err := vm.stack.pop()
+		if err != nil {
+			return err
+		}
+		var res []byte
 		switch v := data.(type) {
-		case int:
-			//  conv to 8 byte int
-			//  why 8 byte? contract use same bit length to read data,if data is stirng, may not work
-			res = tool.IntToBytes(int64(v))
+		case *big.Int:
+			// words are stored as 32 byte big endian
+			res = wordBytes(v)
 		case []byte:
-			res = data.([]byte)
+			res = v
+		default:
+			return fmt.Errorf("%w: can not store %T", ErrBadOperand, v)
 		}
-		// fmt.Printf("key: %v , value: %v", key, res)
-		vm.contractstate.put(string(key), res)
+		if err := vm.useGas(uint64(len(res)) * gasStoreByte); err != nil {
+			return err
+		}
+		vm.contractstate.put(vm.namespace+string(key), res)
+		vm.writes = append(vm.writes, vm.namespace+string(key))
+		vm.traceWrite(vm.namespace+string(key), res)
 	case instrGet:
-		key := vm.stack.pop().([]byte)
-		value, err := vm.contractstate.get(string(key))
+		key, err := vm.stack.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.contractstate.get(vm.namespace + string(key))
+		vm.traceRead(vm.namespace+string(key), value)
 		if err != nil {
-			return fmt.Errorf("key not found")
+			return fmt.Errorf("%w: %s", e.ErrKeyUnKnown, key)
 		}
-		vm.stack.push(value)
+		return vm.stack.push(value)
+	default:
+		return ErrUnknownOpcode
 	}
 	return nil
 }
@@ -1,94 +1,398 @@
 package core
 
 import (
-	"blockchain/pkg/utils/tool"
+	"blockchain/pkg/e"
+	"bytes"
+	"errors"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// testGas is plenty for every program in the tests
+const testGas = 100_000
+
+// stackItems returns the stack bottom up with words that fit converted to
+// uint64, so tests can compare against literals
+func stackItems(vm *VM) []any {
+	items := make([]any, vm.stack.sp)
+	for i, v := range vm.stack.data[:vm.stack.sp] {
+		if w, ok := v.(*big.Int); ok && w.IsUint64() {
+			v = w.Uint64()
+		}
+		items[i] = v
+	}
+	return items
+}
+
+func popUint(t *testing.T, vm *VM) uint64 {
+	w, err := vm.stack.popWord()
+	assert.Nil(t, err)
+	assert.True(t, w.IsUint64())
+	return w.Uint64()
+}
+
 func TestVMInt(t *testing.T) {
-	data := []byte{0x01, 0x0a, 0x03, 0x0a, 0x0b}
+	data := []byte{0x20, 0x01, 0x20, 0x03, 0x0b}
 
-	vm := NewVM(data, NewContractState())
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	// fmt.Println(vm.stack.data...)
 
 	assert.Nil(t, err)
-	res := vm.stack.pop()
-	assert.Equal(t, 4, res.(int))
+	assert.Equal(t, uint64(4), popUint(t, vm))
 }
 
 func TestStack(t *testing.T) {
 	s := NewStack(1024)
-	s.push(0x01)
-	s.push(0x02)
-	res := s.pop()
+	assert.Nil(t, s.push(0x01))
+	assert.Nil(t, s.push(0x02))
+	res, err := s.pop()
+	assert.Nil(t, err)
 	assert.Equal(t, 0x02, res.(int))
 }
 
 func TestStringData(t *testing.T) {
-	data := []byte{0x46, 0x0c, 0x79, 0x0c, 0x65, 0x0c, 0x6c, 0x0c, 0x6f, 0x0c, 0x20, 0x0c, 0x57, 0x0c, 0x6f, 0x0c, 0x72, 0x0c, 0x6c, 0x0c, 0x64, 0x0c, 0x21, 0x0c, 0x09, 0x0a, 0x0d}
-	vm := NewVM(data, NewContractState())
+	data := []byte{0x29, 0x46, 0x29, 0x79, 0x29, 0x65, 0x29, 0x6c, 0x29, 0x6f, 0x29, 0x20, 0x29, 0x57, 0x29, 0x6f, 0x29, 0x72, 0x29, 0x6c, 0x29, 0x64, 0x29, 0x21, 0x20, 0x09, 0x0d}
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	// fmt.Println(vm.stack.pop().([]byte))
 	assert.Nil(t, err)
 }
 
 func TestMinus(t *testing.T) {
-	data := []byte{0x01, 0x0a, 0x03, 0x0a, 0x0e}
-	vm := NewVM(data, NewContractState())
+	data := []byte{0x20, 0x01, 0x20, 0x03, 0x0e}
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	assert.Nil(t, err)
-	res := vm.stack.pop().(int)
-	assert.Equal(t, res, 2)
+	assert.Equal(t, uint64(2), popUint(t, vm))
 }
 
 func TestInstrStoreString(t *testing.T) {
 	// [f o o] 3 pack [f] 1 pack
 	// [foo] [f] store
 	// when value is string
-	data := []byte{0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x46, 0x0c, 0x01, 0x0a, 0x0d, 0x0f}
+	data := []byte{0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f}
 
-	vm := NewVM(data, NewContractState())
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	assert.Nil(t, err)
 	assert.Equal(t, vm.contractstate.data["F"], []byte("OOF"))
 }
 
 func TestInstrStoreInt(t *testing.T) {
-	data := []byte{0x01, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+	data := []byte{0x20, 0x01, 0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x0f}
 
-	vm := NewVM(data, NewContractState())
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	assert.Nil(t, err)
 	value, err := vm.contractstate.get("OOF")
 	assert.Nil(t, err)
-	assert.Equal(t, tool.BytesToInt(value), int64(1))
+	assert.Equal(t, wordBytes(newWord(1)), value)
 }
 
 func TestInstrMult(t *testing.T) {
-	data := []byte{0x02, 0x0a, 0x05, 0x0a, 0x11}
-	vm := NewVM(data, NewContractState())
+	data := []byte{0x20, 0x02, 0x20, 0x05, 0x11}
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	assert.Nil(t, err)
-	assert.Equal(t, vm.stack.data[0], int(10))
+	assert.Equal(t, []any{uint64(10)}, stackItems(vm))
 }
 
 func TestInstrDiv(t *testing.T) {
-	data := []byte{0x02, 0x0a, 0x04, 0x0a, 0x12}
-	vm := NewVM(data, NewContractState())
+	data := []byte{0x20, 0x02, 0x20, 0x04, 0x12}
+	vm := NewVM(data, NewContractState(), testGas)
 	err := vm.run()
 	assert.Nil(t, err)
-	assert.Equal(t, vm.stack.data[0], int(2))
+	assert.Equal(t, []any{uint64(2)}, stackItems(vm))
 }
 
 func TestInstrGet(t *testing.T) {
-	store := []byte{0x01, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
-	key := []byte{0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x10}
+	store := []byte{0x20, 0x01, 0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x0f}
+	key := []byte{0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x10}
 	key = append(store, key...)
-	vm := NewVM(key, NewContractState())
+	vm := NewVM(key, NewContractState(), testGas)
 	err := vm.run()
 	assert.Nil(t, err)
-	assert.Equal(t, vm.stack.data[0], tool.IntToBytes(1))
+	assert.Equal(t, []any{wordBytes(newWord(1))}, stackItems(vm))
+}
+
+func TestStackBounds(t *testing.T) {
+	s := NewStack(1)
+	_, err := s.pop()
+	assert.ErrorIs(t, err, ErrStackUnderflow)
+	assert.Nil(t, s.push(1))
+	assert.ErrorIs(t, s.push(2), ErrStackOverflow)
+	_, err = s.popBytes()
+	assert.ErrorIs(t, err, ErrBadOperand)
+}
+
+func TestVMErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"truncated push":       {[]byte{0x21, 0x01}, ErrTruncatedCode},
+		"truncated push byte":  {[]byte{0x29}, ErrTruncatedCode},
+		"truncated push bytes": {[]byte{0x2a, 0x03, 0x01}, ErrTruncatedCode},
+		"legacy push":          {[]byte{0x01, 0x0a}, ErrUnknownOpcode},
+		"dup empty":            {[]byte{0x25, 0x01}, ErrStackUnderflow},
+		"dup zero":             {[]byte{0x20, 0x01, 0x25, 0x00}, ErrStackUnderflow},
+		"swap one item":        {[]byte{0x20, 0x01, 0x26, 0x01}, ErrStackUnderflow},
+		"pop empty":            {[]byte{0x27}, ErrStackUnderflow},
+		"concat ints":          {[]byte{0x20, 0x01, 0x20, 0x01, 0x28}, ErrBadOperand},
+		"add on empty":         {[]byte{0x0b}, ErrStackUnderflow},
+		"add one operand":      {[]byte{0x20, 0x01, 0x0b}, ErrStackUnderflow},
+		"add bytes":            {[]byte{0x29, 0x46, 0x20, 0x01, 0x0b}, ErrBadOperand},
+		"div by zero":          {[]byte{0x20, 0x00, 0x20, 0x04, 0x12}, ErrDivisionByZero},
+		"pack too many":        {[]byte{0x29, 0x46, 0x20, 0x05, 0x0d}, ErrStackUnderflow},
+		"pack ints":            {[]byte{0x20, 0x01, 0x20, 0x01, 0x0d}, ErrBadOperand},
+		"store int key":        {[]byte{0x20, 0x01, 0x20, 0x01, 0x0f}, ErrBadOperand},
+		"store byte value":     {[]byte{0x29, 0x46, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f}, ErrBadOperand},
+		"get missing key":      {[]byte{0x29, 0x46, 0x20, 0x01, 0x0d, 0x10}, e.ErrKeyUnKnown},
+		"eq word and bytes":    {[]byte{0x29, 0x46, 0x20, 0x01, 0x0d, 0x20, 0x01, 0x13}, ErrBadOperand},
+		"unknown opcode":       {[]byte{0xff}, ErrUnknownOpcode},
+	} {
+		vm := NewVM(tc.data, NewContractState(), testGas)
+		err := vm.run()
+		assert.ErrorIs(t, err, tc.err, name)
+		var execErr *ExecutionError
+		assert.True(t, errors.As(err, &execErr), name)
+	}
+
+	// overflow the stack by pushing in a loop of bytes
+	data := []byte{}
+	for i := 0; i <= stackSize; i++ {
+		data = append(data, 0x20, 0x01)
+	}
+	err := NewVM(data, NewContractState(), testGas).run()
+	assert.ErrorIs(t, err, ErrStackOverflow)
+
+	assert.Nil(t, NewVM(nil, NewContractState(), testGas).run())
+}
+
+func TestVMPush(t *testing.T) {
+	data := []byte{
+		0x20, 0x01,
+		0x21, 0x01, 0x02,
+		0x22, 0x01, 0x02, 0x03, 0x04,
+		0x23, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	}
+	data = append(data, 0x24)
+	data = append(data, make([]byte, 30)...)
+	data = append(data, 0x12, 0x34)
+	data = append(data, 0x2a, 0x03, 'f', 'o', 'o')
+	vm := NewVM(data, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{uint64(1), uint64(0x0102), uint64(0x01020304), uint64(1 << 32), uint64(0x1234), []byte("foo")}, stackItems(vm))
+}
+
+func TestVMStackOps(t *testing.T) {
+	// 1 2 3, dup 3, swap 2, pop
+	data := []byte{0x20, 0x01, 0x20, 0x02, 0x20, 0x03, 0x25, 0x03, 0x26, 0x02, 0x27}
+	vm := NewVM(data, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	// 1 2 3 -> 1 2 3 1 -> 1 1 3 2 -> 1 1 3
+	assert.Equal(t, []any{uint64(1), uint64(1), uint64(3)}, stackItems(vm))
+
+	// "foo" "bar" concat
+	data = []byte{0x2a, 0x03, 'f', 'o', 'o', 0x2a, 0x03, 'b', 'a', 'r', 0x28}
+	vm = NewVM(data, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	res, err := vm.stack.popBytes()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("foobar"), res)
+}
+
+func FuzzVM(f *testing.F) {
+	f.Add([]byte{0x20, 0x01, 0x20, 0x03, 0x0b})
+	f.Add([]byte{0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f})
+	f.Add([]byte{0x20, 0x01, 0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x0f, 0x29, 0x46, 0x29, 0x4f, 0x29, 0x4f, 0x20, 0x03, 0x0d, 0x10})
+	f.Add([]byte{0x20, 0x02, 0x20, 0x00, 0x12})
+	f.Add([]byte{0x2a, 0x03, 'f', 'o', 'o', 0x25, 0x01, 0x28})
+	f.Add([]byte{0x24, 0x01})
+	f.Add([]byte{0x20, 0x00, 0x20, 0x01, 0x20, 0x01, 0x1b, 0x20, 0x06, 0x1a, 0x1c})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		err := NewVM(data, NewContractState(), testGas).run()
+		if err == nil {
+			return
+		}
+		var execErr *ExecutionError
+		if !errors.As(err, &execErr) {
+			t.Fatalf("untyped vm error %v", err)
+		}
+	})
+}
+
+func TestVMCompare(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data []byte
+		res  uint64
+	}{
+		"eq":       {[]byte{0x20, 0x02, 0x20, 0x02, 0x13}, 1},
+		"not eq":   {[]byte{0x20, 0x01, 0x20, 0x02, 0x13}, 0},
+		"lt":       {[]byte{0x20, 0x03, 0x20, 0x01, 0x14}, 1},
+		"not lt":   {[]byte{0x20, 0x01, 0x20, 0x03, 0x14}, 0},
+		"gt":       {[]byte{0x20, 0x01, 0x20, 0x03, 0x15}, 1},
+		"not gt":   {[]byte{0x20, 0x03, 0x20, 0x03, 0x15}, 0},
+		"and":      {[]byte{0x20, 0x02, 0x20, 0x01, 0x16}, 1},
+		"and zero": {[]byte{0x20, 0x00, 0x20, 0x01, 0x16}, 0},
+		"or":       {[]byte{0x20, 0x00, 0x20, 0x05, 0x17}, 1},
+		"or zero":  {[]byte{0x20, 0x00, 0x20, 0x00, 0x17}, 0},
+		"not":      {[]byte{0x20, 0x00, 0x18}, 1},
+		"not one":  {[]byte{0x20, 0x07, 0x18}, 0},
+	} {
+		vm := NewVM(tc.data, NewContractState(), testGas)
+		assert.Nil(t, vm.run(), name)
+		assert.Equal(t, []any{tc.res}, stackItems(vm), name)
+	}
+}
+
+func TestVMJump(t *testing.T) {
+	// 0 push 1 push 1 push, loop: jumpdest 6 push jumpi, halt
+	// every pass pops one flag, the third one ends the loop
+	loop := []byte{0x20, 0x00, 0x20, 0x01, 0x20, 0x01, 0x1b, 0x20, 0x06, 0x1a, 0x1c}
+	vm := NewVM(loop, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, 0, vm.stack.sp)
+	// jumpdest runs again after every taken jump
+	assert.Equal(t, 3+3*(1+2)+1, vm.steps)
+
+	// 5 push jump, 9 push, jumpdest 4 push return
+	// the jump skips over pushing 9
+	skip := []byte{0x20, 0x05, 0x19, 0x20, 0x09, 0x1b, 0x20, 0x04, 0x1d, 0x20, 0x01}
+	vm = NewVM(skip, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, wordBytes(newWord(4)), vm.ret)
+	// return halts, the trailing push never runs
+	assert.Equal(t, 0, vm.stack.sp)
+
+	// not taken
+	vm = NewVM([]byte{0x20, 0x00, 0x20, 0x07, 0x1a, 0x20, 0x03}, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{uint64(3)}, stackItems(vm))
+
+	for name, data := range map[string][]byte{
+		"out of range":   {0x20, 0x40, 0x19},
+		"not a jumpdest": {0x20, 0x00, 0x19},
+		// the jumpdest byte at 4 is the operand of the push at 3
+		"into an operand": {0x20, 0x04, 0x19, 0x20, 0x1b},
+	} {
+		err := NewVM(data, NewContractState(), testGas).run()
+		assert.ErrorIs(t, err, ErrInvalidJump, name)
+	}
+}
+
+func TestVMInfiniteLoop(t *testing.T) {
+	// jumpdest 0 push jump
+	loop := []byte{0x1b, 0x20, 0x00, 0x19}
+	vm := NewVM(loop, NewContractState(), 10_000)
+	assert.ErrorIs(t, vm.run(), ErrOutOfGas)
+	assert.Equal(t, uint64(10_000), vm.gasUsed)
+
+	vm = NewVM(loop, NewContractState(), 1<<62)
+	vm.stepLimit = 1000
+	assert.ErrorIs(t, vm.run(), ErrStepLimit)
+	assert.Equal(t, 1000, vm.steps)
+}
+
+func pushWord(w *big.Int) []byte {
+	return append([]byte{instrPush32}, wordBytes(wrap(new(big.Int).Set(w)))...)
+}
+
+func TestVMWords(t *testing.T) {
+	max := new(big.Int).Set(wordMask)
+	minSigned := new(big.Int).Set(wordSignBit)
+	neg := func(v int64) *big.Int { return wrap(big.NewInt(-v)) }
+	word := func(v int64) *big.Int { return big.NewInt(v) }
+
+	for name, tc := range map[string]struct {
+		op   byte
+		a, b *big.Int // a is pushed last and is the left operand
+		res  *big.Int
+	}{
+		"add wraps":          {instrAdd, max, word(1), word(0)},
+		"minus wraps":        {instrMinus, word(0), word(1), max},
+		"mult wraps":         {instrMult, minSigned, word(2), word(0)},
+		"div":                {instrDiv, word(7), word(2), word(3)},
+		"mod":                {instrMod, word(7), word(3), word(1)},
+		"sdiv":               {instrSdiv, neg(7), word(2), neg(3)},
+		"sdiv overflow":      {instrSdiv, minSigned, neg(1), minSigned},
+		"smod":               {instrSmod, neg(7), word(3), neg(1)},
+		"lt is unsigned":     {instrLt, max, word(1), word(0)},
+		"slt":                {instrSlt, neg(1), word(1), word(1)},
+		"sgt":                {instrSgt, word(1), neg(1), word(1)},
+		"exp":                {instrExp, word(3), word(5), word(243)},
+		"exp wraps":          {instrExp, word(2), word(256), word(0)},
+		"exp to sign bit":    {instrExp, word(2), word(255), minSigned},
+		"shl":                {instrShl, word(1), word(255), minSigned},
+		"shl out":            {instrShl, word(1), word(256), word(0)},
+		"shr":                {instrShr, minSigned, word(255), word(1)},
+		"sar":                {instrSar, neg(16), word(2), neg(4)},
+		"sar positive":       {instrSar, word(16), word(2), word(4)},
+		"sar out keeps sign": {instrSar, neg(1), max, max},
+	} {
+		data := append(pushWord(tc.b), pushWord(tc.a)...)
+		vm := NewVM(append(data, tc.op), NewContractState(), testGas)
+		assert.Nil(t, vm.run(), name)
+		res, err := vm.stack.popWord()
+		assert.Nil(t, err, name)
+		assert.Equal(t, tc.res.String(), res.String(), name)
+	}
+
+	for _, op := range []byte{instrDiv, instrMod, instrSdiv, instrSmod} {
+		data := append(pushWord(big.NewInt(0)), pushWord(big.NewInt(1))...)
+		err := NewVM(append(data, op), NewContractState(), testGas).run()
+		assert.ErrorIs(t, err, ErrDivisionByZero)
+	}
+}
+
+func TestStoreWordEncoding(t *testing.T) {
+	// 0x0102 [F] 1 pack store, stored as 32 byte big endian
+	data := []byte{0x21, 0x01, 0x02, 0x29, 0x46, 0x20, 0x01, 0x0d, 0x0f}
+	vm := NewVM(data, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	value, err := vm.contractstate.get("F")
+	assert.Nil(t, err)
+	expected := make([]byte, wordSize)
+	expected[30], expected[31] = 0x01, 0x02
+	assert.Equal(t, expected, value)
+}
+
+func TestVMEqBytes(t *testing.T) {
+	// "ab" "ab" eq, "ab" "a" eq
+	data := []byte{0x2a, 0x02, 'a', 'b', 0x2a, 0x02, 'a', 'b', 0x13, 0x2a, 0x02, 'a', 'b', 0x2a, 0x01, 'a', 0x13}
+	vm := NewVM(data, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{uint64(1), uint64(0)}, stackItems(vm))
+}
+
+func TestVMCallData(t *testing.T) {
+	input := append(bytes.Repeat([]byte{0}, 31), 0x05, 0x07)
+	// calldatasize, 0 calldataload, 32 calldataload, 40 calldataload, calldata
+	data := []byte{0x37, 0x20, 0x00, 0x38, 0x20, 0x20, 0x38, 0x20, 0x28, 0x38, 0x36}
+	vm := NewVM(data, NewContractState(), testGas)
+	vm.input = input
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []any{uint64(33), uint64(5), new(big.Int).Lsh(big.NewInt(7), 248), uint64(0), input}, stackItems(vm))
+
+	// the pushed call data is a copy
+	vm.stack.data[4].([]byte)[0] = 1
+	assert.Equal(t, byte(0), vm.input[0])
+}
+
+func TestVMLog(t *testing.T) {
+	// 7 "topic" log, "x" "t" log
+	data := []byte{0x20, 0x07, 0x2a, 0x01, 't', 0x34, 0x2a, 0x01, 'x', 0x2a, 0x01, 't', 0x34}
+	vm := NewVM(data, NewContractState(), testGas)
+	assert.Nil(t, vm.run())
+	assert.Equal(t, []*Log{
+		{Topic: []byte("t"), Data: wordBytes(newWord(7))},
+		{Topic: []byte("t"), Data: []byte("x")},
+	}, vm.logs)
+
+	err := NewVM([]byte{0x20, 0x07, 0x20, 0x01, 0x34}, NewContractState(), testGas).run()
+	assert.ErrorIs(t, err, ErrBadOperand)
 }
@@ -0,0 +1,61 @@
+package core
+
+import "math/big"
+
+// The vm computes on 256 bit unsigned words held in *big.Int. Every result is
+// reduced mod 2^256, so arithmetic wraps around the same way on every
+// platform. Signed instructions read words as two's complement. A word on the
+// stack is never modified, instructions always push a new one.
+//
+// Stored and returned words are encoded as 32 byte big endian.
+
+const wordSize = 32
+
+var (
+	wordModulus = new(big.Int).Lsh(big.NewInt(1), 8*wordSize)
+	wordMask    = new(big.Int).Sub(wordModulus, big.NewInt(1))
+	wordSignBit = new(big.Int).Lsh(big.NewInt(1), 8*wordSize-1)
+)
+
+func newWord(v uint64) *big.Int {
+	return new(big.Int).SetUint64(v)
+}
+
+// wrap reduces x mod 2^256 in place, negative values become their two's
+// complement
+func wrap(x *big.Int) *big.Int {
+	return x.And(x, wordMask)
+}
+
+// toSigned returns the two's complement value of w
+func toSigned(w *big.Int) *big.Int {
+	if w.Cmp(wordSignBit) < 0 {
+		return new(big.Int).Set(w)
+	}
+	return new(big.Int).Sub(w, wordModulus)
+}
+
+func wordBool(b bool) *big.Int {
+	if b {
+		return newWord(1)
+	}
+	return newWord(0)
+}
+
+// wordBytes encodes w as 32 byte big endian
+func wordBytes(w *big.Int) []byte {
+	return w.FillBytes(make([]byte, wordSize))
+}
+
+// wordFromBytes decodes big endian bytes, anything past 32 bytes is cut off
+func wordFromBytes(b []byte) *big.Int {
+	return wrap(new(big.Int).SetBytes(b))
+}
+
+// wordToInt converts w when it is at most max
+func wordToInt(w *big.Int, max int) (int, bool) {
+	if !w.IsUint64() || w.Uint64() > uint64(max) {
+		return 0, false
+	}
+	return int(w.Uint64()), true
+}
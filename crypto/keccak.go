@@ -0,0 +1,13 @@
+package crypto
+
+import "golang.org/x/crypto/sha3"
+
+// Keccak256 is the original Keccak with a 256 bit output as used by
+// Ethereum, it differs from the standardized SHA3-256 in its padding
+func Keccak256(data []byte) [32]byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	var out [32]byte
+	h.Sum(out[:0])
+	return out
+}
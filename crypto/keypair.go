@@ -72,6 +72,10 @@ func (p PrivateKey) Sign(data []byte) (*Signature, error) {
 
 func (sig Signature) Verify(data []byte, pub PublicKey) bool {
 	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pub)
+	// not a point on the curve
+	if x == nil {
+		return false
+	}
 	pk := &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     x,
@@ -81,7 +85,8 @@ func (sig Signature) Verify(data []byte, pub PublicKey) bool {
 }
 
 func (sig *Signature) ToProto() *pb.Signature {
-	if sig.R != nil && sig.S != nil {
+	// unsigned blocks such as genesis carry no signature
+	if sig != nil && sig.R != nil && sig.S != nil {
 		return &pb.Signature{
 			R: sig.R.Bytes(),
 			S: sig.S.Bytes(),
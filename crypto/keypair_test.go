@@ -28,3 +28,12 @@ func TestKeypairSuccess(t *testing.T) {
 	ok := sig.Verify(data, pub)
 	assert.True(t, ok)
 }
+
+func TestVerifyInvalidPublicKey(t *testing.T) {
+	pri := GenerateKeyPair()
+	data := []byte("hello")
+	sig, err := pri.Sign(data)
+	assert.Nil(t, err)
+	assert.False(t, sig.Verify(data, make(PublicKey, 33)))
+	assert.False(t, sig.Verify(data, nil))
+}
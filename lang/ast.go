@@ -0,0 +1,102 @@
+package lang
+
+import "math/big"
+
+// Expr is an expression node
+type Expr interface {
+	Position() Pos
+}
+
+// Stmt is a statement node
+type Stmt interface {
+	Position() Pos
+}
+
+type (
+	IntLit struct {
+		Pos   Pos
+		Value *big.Int
+	}
+	StringLit struct {
+		Pos   Pos
+		Value []byte
+	}
+	BoolLit struct {
+		Pos   Pos
+		Value bool
+	}
+	Ident struct {
+		Pos  Pos
+		Name string
+	}
+	Unary struct {
+		Pos Pos
+		Op  string
+		X   Expr
+	}
+	Binary struct {
+		Pos  Pos
+		Op   string
+		X, Y Expr
+	}
+	// Call is a builtin call, get as an expression and set or emit as a
+	// statement
+	Call struct {
+		Pos  Pos
+		Name string
+		Args []Expr
+	}
+)
+
+type (
+	Let struct {
+		Pos   Pos
+		Name  string
+		Value Expr
+	}
+	Assign struct {
+		Pos   Pos
+		Name  string
+		Value Expr
+	}
+	If struct {
+		Pos  Pos
+		Cond Expr
+		Then *Block
+		// Else is nil, a *Block or an *If
+		Else Stmt
+	}
+	While struct {
+		Pos  Pos
+		Cond Expr
+		Body *Block
+	}
+	// Return ends the program, Value may be nil
+	Return struct {
+		Pos   Pos
+		Value Expr
+	}
+	CallStmt struct {
+		Call *Call
+	}
+	Block struct {
+		Pos   Pos
+		Stmts []Stmt
+	}
+)
+
+func (x *IntLit) Position() Pos    { return x.Pos }
+func (x *StringLit) Position() Pos { return x.Pos }
+func (x *BoolLit) Position() Pos   { return x.Pos }
+func (x *Ident) Position() Pos     { return x.Pos }
+func (x *Unary) Position() Pos     { return x.Pos }
+func (x *Binary) Position() Pos    { return x.Pos }
+func (x *Call) Position() Pos      { return x.Pos }
+
+func (s *Let) Position() Pos      { return s.Pos }
+func (s *Assign) Position() Pos   { return s.Pos }
+func (s *If) Position() Pos       { return s.Pos }
+func (s *While) Position() Pos    { return s.Pos }
+func (s *Return) Position() Pos   { return s.Pos }
+func (s *CallStmt) Position() Pos { return s.Call.Pos }
+func (s *Block) Position() Pos    { return s.Pos }
@@ -0,0 +1,262 @@
+package lang
+
+// Type is the type of an expression
+type Type int
+
+const (
+	// Int is a 256 bit two's complement integer
+	Int Type = iota + 1
+	Bytes
+	Bool
+)
+
+func (t Type) String() string {
+	switch t {
+	case Int:
+		return "int"
+	case Bytes:
+		return "bytes"
+	case Bool:
+		return "bool"
+	}
+	return "invalid"
+}
+
+// builtin describes a builtin function
+type builtin struct {
+	params []Type
+	// result is 0 for builtins that are statements
+	result Type
+}
+
+// a parameter of type 0 takes any type
+var builtins = map[string]builtin{
+	"get":      {params: []Type{Bytes}, result: Bytes},
+	"calldata": {result: Bytes},
+	// the environment of the call
+	"caller":    {result: Bytes},
+	"value":     {result: Int},
+	"height":    {result: Int},
+	"timestamp": {result: Int},
+	"validator": {result: Bytes},
+	"balance":   {result: Int},
+	"set":       {params: []Type{Bytes, 0}},
+	"emit":      {params: []Type{Bytes, 0}},
+}
+
+type checker struct {
+	scopes []map[string]Type
+	types  map[Expr]Type
+}
+
+// Check type checks a parsed program and returns the type of every
+// expression
+func Check(prog *Block) (map[Expr]Type, error) {
+	c := &checker{types: map[Expr]Type{}}
+	if err := c.block(prog); err != nil {
+		return nil, err
+	}
+	return c.types, nil
+}
+
+func (c *checker) lookup(name string) (Type, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if t, ok := c.scopes[i][name]; ok {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+func (c *checker) block(b *Block) error {
+	c.scopes = append(c.scopes, map[string]Type{})
+	defer func() { c.scopes = c.scopes[:len(c.scopes)-1] }()
+	for _, stmt := range b.Stmts {
+		if err := c.stmt(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) stmt(stmt Stmt) error {
+	switch s := stmt.(type) {
+	case *Let:
+		t, err := c.expr(s.Value)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.lookup(s.Name); ok {
+			return errorf(s.Pos, "%s is already declared", s.Name)
+		}
+		if _, ok := builtins[s.Name]; ok {
+			return errorf(s.Pos, "%s is a builtin", s.Name)
+		}
+		c.scopes[len(c.scopes)-1][s.Name] = t
+	case *Assign:
+		want, ok := c.lookup(s.Name)
+		if !ok {
+			return errorf(s.Pos, "undeclared name %s", s.Name)
+		}
+		t, err := c.expr(s.Value)
+		if err != nil {
+			return err
+		}
+		if t != want {
+			return errorf(s.Pos, "can not assign %s to %s of type %s", t, s.Name, want)
+		}
+	case *If:
+		if err := c.cond(s.Cond); err != nil {
+			return err
+		}
+		if err := c.block(s.Then); err != nil {
+			return err
+		}
+		if s.Else != nil {
+			return c.stmt(s.Else)
+		}
+	case *While:
+		if err := c.cond(s.Cond); err != nil {
+			return err
+		}
+		return c.block(s.Body)
+	case *Return:
+		if s.Value != nil {
+			_, err := c.expr(s.Value)
+			return err
+		}
+	case *CallStmt:
+		if b, ok := builtins[s.Call.Name]; ok && b.result != 0 {
+			return errorf(s.Call.Pos, "result of %s is not used", s.Call.Name)
+		}
+		_, err := c.call(s.Call)
+		return err
+	case *Block:
+		return c.block(s)
+	default:
+		return errorf(stmt.Position(), "unexpected statement %T", stmt)
+	}
+	return nil
+}
+
+func (c *checker) cond(x Expr) error {
+	t, err := c.expr(x)
+	if err != nil {
+		return err
+	}
+	if t != Bool {
+		return errorf(x.Position(), "condition must be bool, got %s", t)
+	}
+	return nil
+}
+
+func (c *checker) expr(x Expr) (Type, error) {
+	t, err := c.typeOf(x)
+	if err != nil {
+		return 0, err
+	}
+	c.types[x] = t
+	return t, nil
+}
+
+func (c *checker) typeOf(x Expr) (Type, error) {
+	switch x := x.(type) {
+	case *IntLit:
+		if x.Value.BitLen() > 256 {
+			return 0, errorf(x.Pos, "%s does not fit in 256 bits", x.Value)
+		}
+		return Int, nil
+	case *StringLit:
+		return Bytes, nil
+	case *BoolLit:
+		return Bool, nil
+	case *Ident:
+		t, ok := c.lookup(x.Name)
+		if !ok {
+			return 0, errorf(x.Pos, "undeclared name %s", x.Name)
+		}
+		return t, nil
+	case *Unary:
+		t, err := c.expr(x.X)
+		if err != nil {
+			return 0, err
+		}
+		want := Int
+		if x.Op == "!" {
+			want = Bool
+		}
+		if t != want {
+			return 0, errorf(x.Pos, "operator %s needs %s, got %s", x.Op, want, t)
+		}
+		return t, nil
+	case *Binary:
+		return c.binary(x)
+	case *Call:
+		t, err := c.call(x)
+		if err != nil {
+			return 0, err
+		}
+		if t == 0 {
+			return 0, errorf(x.Pos, "%s has no value", x.Name)
+		}
+		return t, nil
+	}
+	return 0, errorf(x.Position(), "unexpected expression %T", x)
+}
+
+func (c *checker) binary(x *Binary) (Type, error) {
+	left, err := c.expr(x.X)
+	if err != nil {
+		return 0, err
+	}
+	right, err := c.expr(x.Y)
+	if err != nil {
+		return 0, err
+	}
+	if left != right {
+		return 0, errorf(x.Pos, "mismatched types %s %s %s", left, x.Op, right)
+	}
+	switch x.Op {
+	case "+":
+		if left == Int || left == Bytes {
+			return left, nil
+		}
+	case "-", "*", "/", "%":
+		if left == Int {
+			return Int, nil
+		}
+	case "==", "!=":
+		if left == Int || left == Bool || left == Bytes {
+			return Bool, nil
+		}
+	case "<", ">", "<=", ">=":
+		if left == Int {
+			return Bool, nil
+		}
+	case "&&", "||":
+		if left == Bool {
+			return Bool, nil
+		}
+	}
+	return 0, errorf(x.Pos, "operator %s is not defined on %s", x.Op, left)
+}
+
+func (c *checker) call(x *Call) (Type, error) {
+	b, ok := builtins[x.Name]
+	if !ok {
+		return 0, errorf(x.Pos, "unknown function %s", x.Name)
+	}
+	if len(x.Args) != len(b.params) {
+		return 0, errorf(x.Pos, "%s takes %d arguments, got %d", x.Name, len(b.params), len(x.Args))
+	}
+	for i, arg := range x.Args {
+		t, err := c.expr(arg)
+		if err != nil {
+			return 0, err
+		}
+		if b.params[i] != 0 && t != b.params[i] {
+			return 0, errorf(arg.Position(), "argument %d of %s must be %s, got %s", i+1, x.Name, b.params[i], t)
+		}
+	}
+	return b.result, nil
+}
@@ -0,0 +1,286 @@
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxStringChunk is the most bytes a single pushbytes carries
+const maxStringChunk = 255
+
+// instructions of the operators, the left operand is on top of the stack
+var binaryOps = map[string][]string{
+	"+":  {"add"},
+	"-":  {"minus"},
+	"*":  {"mult"},
+	"/":  {"sdiv"},
+	"%":  {"smod"},
+	"==": {"eq"},
+	"!=": {"eq", "not"},
+	"<":  {"slt"},
+	">":  {"sgt"},
+	"<=": {"sgt", "not"},
+	">=": {"slt", "not"},
+	"&&": {"and"},
+	"||": {"or"},
+}
+
+// how many items each instruction adds to the stack, the others leave its
+// depth alone
+var stackEffect = map[string]int{
+	"push": 1, "pushbytes": 1, "dup": 1, "calldata": 1,
+	"caller": 1, "callvalue": 1, "height": 1, "timestamp": 1, "validator": 1, "selfbalance": 1,
+	"pop": -1, "jump": -1, "return": -1,
+	"add": -1, "minus": -1, "mult": -1, "sdiv": -1, "smod": -1, "concat": -1,
+	"eq": -1, "slt": -1, "sgt": -1, "and": -1, "or": -1,
+	"jumpi": -2, "store": -2, "log": -2,
+}
+
+type local struct {
+	name string
+	slot int
+}
+
+// generator emits assembly for core.VM. Locals live on the stack: slot is the
+// index from the bottom and depth counts the items currently pushed, so a
+// local is read with dup depth-slot.
+type generator struct {
+	buf    strings.Builder
+	types  map[Expr]Type
+	lines  []string
+	locals []local
+	depth  int
+	labels int
+}
+
+func generate(src string, prog *Block, types map[Expr]Type) (string, error) {
+	g := &generator{types: types, lines: strings.Split(src, "\n")}
+	for _, stmt := range prog.Stmts {
+		if err := g.stmt(stmt); err != nil {
+			return "", err
+		}
+	}
+	g.emit("halt")
+	return g.buf.String(), nil
+}
+
+// emit writes an instruction and tracks how it changes the stack depth
+func (g *generator) emit(instr string, args ...any) {
+	fmt.Fprintf(&g.buf, "    %s", instr)
+	for _, arg := range args {
+		fmt.Fprintf(&g.buf, " %v", arg)
+	}
+	g.buf.WriteString("\n")
+	g.depth += stackEffect[instr]
+}
+
+func (g *generator) label(name string) {
+	fmt.Fprintf(&g.buf, "%s:\n", name)
+	g.emit("jumpdest")
+}
+
+func (g *generator) newLabel(prefix string) string {
+	g.labels++
+	return fmt.Sprintf("%s_%d", prefix, g.labels)
+}
+
+// comment copies the source line of a statement into the output
+func (g *generator) comment(pos Pos) {
+	if pos.Line <= len(g.lines) {
+		fmt.Fprintf(&g.buf, "    ; %d: %s\n", pos.Line, strings.TrimSpace(g.lines[pos.Line-1]))
+	}
+}
+
+// stackDistance returns the dup or swap operand that reaches slot
+func (g *generator) stackDistance(pos Pos, slot int) (int, error) {
+	n := g.depth - slot
+	if n > 255 {
+		return 0, errorf(pos, "too many values on the stack")
+	}
+	return n, nil
+}
+
+func (g *generator) lookup(name string) local {
+	for i := len(g.locals) - 1; i >= 0; i-- {
+		if g.locals[i].name == name {
+			return g.locals[i]
+		}
+	}
+	// the checker rejects undeclared names
+	panic("undeclared name " + name)
+}
+
+func (g *generator) block(b *Block) error {
+	n := len(g.locals)
+	for _, stmt := range b.Stmts {
+		if err := g.stmt(stmt); err != nil {
+			return err
+		}
+	}
+	for range g.locals[n:] {
+		g.emit("pop")
+	}
+	g.locals = g.locals[:n]
+	return nil
+}
+
+func (g *generator) stmt(stmt Stmt) error {
+	if _, ok := stmt.(*Block); !ok {
+		g.comment(stmt.Position())
+	}
+	switch s := stmt.(type) {
+	case *Let:
+		if err := g.expr(s.Value); err != nil {
+			return err
+		}
+		g.locals = append(g.locals, local{name: s.Name, slot: g.depth - 1})
+	case *Assign:
+		if err := g.expr(s.Value); err != nil {
+			return err
+		}
+		n, err := g.stackDistance(s.Pos, g.lookup(s.Name).slot+1)
+		if err != nil {
+			return err
+		}
+		g.emit("swap", n)
+		g.emit("pop")
+	case *If:
+		elseLabel, end := g.newLabel("else"), g.newLabel("end")
+		if err := g.expr(s.Cond); err != nil {
+			return err
+		}
+		g.emit("not")
+		g.emit("push", "@"+elseLabel)
+		g.emit("jumpi")
+		if err := g.block(s.Then); err != nil {
+			return err
+		}
+		g.emit("push", "@"+end)
+		g.emit("jump")
+		g.label(elseLabel)
+		if s.Else != nil {
+			if err := g.stmt(s.Else); err != nil {
+				return err
+			}
+		}
+		g.label(end)
+	case *While:
+		loop, done := g.newLabel("loop"), g.newLabel("done")
+		g.label(loop)
+		if err := g.expr(s.Cond); err != nil {
+			return err
+		}
+		g.emit("not")
+		g.emit("push", "@"+done)
+		g.emit("jumpi")
+		if err := g.block(s.Body); err != nil {
+			return err
+		}
+		g.emit("push", "@"+loop)
+		g.emit("jump")
+		g.label(done)
+	case *Return:
+		if s.Value == nil {
+			g.emit("halt")
+			return nil
+		}
+		if err := g.expr(s.Value); err != nil {
+			return err
+		}
+		g.emit("return")
+	case *CallStmt:
+		return g.call(s.Call)
+	case *Block:
+		return g.block(s)
+	default:
+		return errorf(stmt.Position(), "unexpected statement %T", stmt)
+	}
+	return nil
+}
+
+func (g *generator) expr(x Expr) error {
+	switch x := x.(type) {
+	case *IntLit:
+		g.emit("push", x.Value)
+	case *BoolLit:
+		if x.Value {
+			g.emit("push", 1)
+		} else {
+			g.emit("push", 0)
+		}
+	case *StringLit:
+		g.emit("pushbytes", strconv.Quote(string(x.Value[:min(len(x.Value), maxStringChunk)])))
+		for i := maxStringChunk; i < len(x.Value); i += maxStringChunk {
+			g.emit("pushbytes", strconv.Quote(string(x.Value[i:min(len(x.Value), i+maxStringChunk)])))
+			g.emit("concat")
+		}
+	case *Ident:
+		n, err := g.stackDistance(x.Pos, g.lookup(x.Name).slot)
+		if err != nil {
+			return err
+		}
+		g.emit("dup", n)
+	case *Unary:
+		if err := g.expr(x.X); err != nil {
+			return err
+		}
+		if x.Op == "!" {
+			g.emit("not")
+		} else {
+			// 0 - x
+			g.emit("push", 0)
+			g.emit("minus")
+		}
+	case *Binary:
+		if x.Op == "+" && g.types[x.X] == Bytes {
+			// concat appends the top to the item below it
+			return g.operands(x.X, x.Y, "concat")
+		}
+		return g.operands(x.Y, x.X, binaryOps[x.Op]...)
+	case *Call:
+		return g.call(x)
+	default:
+		return errorf(x.Position(), "unexpected expression %T", x)
+	}
+	return nil
+}
+
+// operands pushes a and then b, so b ends up on top, and applies instrs
+func (g *generator) operands(a, b Expr, instrs ...string) error {
+	if err := g.expr(a); err != nil {
+		return err
+	}
+	if err := g.expr(b); err != nil {
+		return err
+	}
+	for _, instr := range instrs {
+		g.emit(instr)
+	}
+	return nil
+}
+
+func (g *generator) call(x *Call) error {
+	switch x.Name {
+	case "get":
+		if err := g.expr(x.Args[0]); err != nil {
+			return err
+		}
+		g.emit("get")
+	case "calldata", "caller", "height", "timestamp", "validator":
+		g.emit(x.Name)
+	case "value":
+		g.emit("callvalue")
+	case "balance":
+		g.emit("selfbalance")
+	case "set":
+		// store pops the key and then the value
+		return g.operands(x.Args[1], x.Args[0], "store")
+	case "emit":
+		// log pops the topic and then the data
+		return g.operands(x.Args[1], x.Args[0], "log")
+	default:
+		return errorf(x.Pos, "unknown function %s", x.Name)
+	}
+	return nil
+}
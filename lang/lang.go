@@ -0,0 +1,57 @@
+// Package lang compiles a small expression language to core.VM bytecode.
+//
+//	// sums the even numbers below 20
+//	let n = 0;
+//	let i = 0;
+//	while i < 10 {
+//	    n = n + i * 2;
+//	    i = i + 1;
+//	}
+//	if n > 50 && true {
+//	    set("sum", n);
+//	} else {
+//	    emit("small", "sum " + "too small");
+//	}
+//	return n;
+//
+// Values are int, a 256 bit two's complement integer, bytes and bool. A let
+// declares a variable in the enclosing block, names can not be redeclared
+// while they are visible. Conditions must be bool, && and || evaluate both
+// sides. The builtins are get(key) and calldata(), which return bytes,
+// set(key, value) and emit(topic, data). caller(), value(), height(),
+// timestamp(), validator() and balance() return the environment of the call,
+// timestamp() is the block time in Unix nanoseconds.
+// A program ends at the last statement or at return, which
+// returns a value or just halts.
+package lang
+
+import (
+	"blockchain/asm"
+	"fmt"
+)
+
+// CompileAsm compiles src to assembly for package asm
+func CompileAsm(src string) (string, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return "", err
+	}
+	types, err := Check(prog)
+	if err != nil {
+		return "", err
+	}
+	return generate(src, prog, types)
+}
+
+// Compile compiles src to bytecode
+func Compile(src string) ([]byte, error) {
+	text, err := CompileAsm(src)
+	if err != nil {
+		return nil, err
+	}
+	code, err := asm.Assemble(text)
+	if err != nil {
+		return nil, fmt.Errorf("assemble generated code: %w", err)
+	}
+	return code, nil
+}
@@ -0,0 +1,111 @@
+package lang
+
+import (
+	"blockchain/core"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// TestGolden compiles testdata/*.src and compares the assembly with the
+// .golden file next to it
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.src")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			assert.Nil(t, err)
+			text, err := CompileAsm(string(src))
+			assert.Nil(t, err)
+
+			golden := strings.TrimSuffix(file, ".src") + ".golden"
+			if *update {
+				assert.Nil(t, os.WriteFile(golden, []byte(text), 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			assert.Nil(t, err)
+			assert.Equal(t, string(expected), text)
+
+			code, err := Compile(string(src))
+			assert.Nil(t, err)
+			// compiled code always passes the deploy checks
+			assert.Nil(t, core.VerifyCode(code))
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	code, err := Compile(`let x = 2; return x + 1;`)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x20, 0x02, // push 2
+		0x20, 0x01, // push 1
+		0x25, 0x02, // dup 2
+		0x0b, // add
+		0x1d, // return
+		0x1c, // halt
+	}, code)
+}
+
+func TestCompileErrors(t *testing.T) {
+	for src, msg := range map[string]string{
+		`let x = 1`:                     `1:10: expected ";"`,
+		`let x = 1; let x = 2;`:         "1:12: x is already declared",
+		`let x = 1; { let x = 2; }`:     "1:14: x is already declared",
+		`{ let x = 1; } x = 2;`:         "1:16: undeclared name x",
+		`let x = 1; x = "a";`:           "can not assign bytes to x of type int",
+		`if 1 { }`:                      "condition must be bool, got int",
+		`while "a" { }`:                 "condition must be bool, got bytes",
+		`let x = 1 + "a";`:              "mismatched types int + bytes",
+		`let x = "a" < "b";`:            "operator < is not defined on bytes",
+		`let x = true + false;`:         "operator + is not defined on bool",
+		`let x = !1;`:                   "operator ! needs bool, got int",
+		`let x = -true;`:                "operator - needs int, got bool",
+		`let x = set("a", 1);`:          "set has no value",
+		`get("a");`:                     "result of get is not used",
+		`set(1, 1);`:                    "argument 1 of set must be bytes, got int",
+		`emit("a");`:                    "emit takes 2 arguments, got 1",
+		`foo();`:                        "unknown function foo",
+		`let get = 1;`:                  "get is a builtin",
+		`let x = 0x1` + zeros(64) + `;`: "does not fit in 256 bits",
+		`let x = "open;`:                "unterminated string",
+		`let x = 1 # 2;`:                "unexpected character '#'",
+		`let x = 08z;`:                  "invalid number",
+		`if true { `:                    `expected "}", got end of input`,
+		`1;`:                            "expected a statement",
+		"let x = 1;\n  y = 2;":          "2:3: undeclared name y",
+	} {
+		_, err := Compile(src)
+		assert.ErrorContains(t, err, msg, src)
+	}
+}
+
+func TestStackLimit(t *testing.T) {
+	nested := func(n int) string {
+		src := &strings.Builder{}
+		src.WriteString("let x = 1;\n")
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(src, "{ let a%d = 0; ", i)
+		}
+		src.WriteString("x = 2;")
+		src.WriteString(strings.Repeat("}", n))
+		return src.String()
+	}
+	_, err := Compile(nested(254))
+	assert.Nil(t, err)
+	_, err = Compile(nested(255))
+	assert.ErrorContains(t, err, "too many values on the stack")
+}
+
+func zeros(n int) string {
+	return strings.Repeat("0", n)
+}
@@ -0,0 +1,173 @@
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokKeyword
+	tokOp // operators and punctuation
+)
+
+var keywords = map[string]bool{
+	"let":    true,
+	"if":     true,
+	"else":   true,
+	"while":  true,
+	"return": true,
+	"true":   true,
+	"false":  true,
+}
+
+// operators, longest first so "==" wins over "="
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "=",
+	"(", ")", "{", "}", ",", ";",
+}
+
+// Pos is a line and column in the source, both start at 1
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+}
+
+// Error is a compile error at a source position
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.Pos, err.Msg)
+}
+
+func errorf(pos Pos, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+type lexer struct {
+	src  []rune
+	i    int
+	line int
+	col  int
+}
+
+// lex splits src into tokens, the last one is tokEOF
+func lex(src string) ([]token, error) {
+	l := &lexer{src: []rune(src), line: 1, col: 1}
+	tokens := []token{}
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peek(off int) rune {
+	if l.i+off < len(l.src) {
+		return l.src[l.i+off]
+	}
+	return 0
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.i]
+	l.i++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) next() (token, error) {
+	// skip white space and // comments
+	for l.i < len(l.src) {
+		if unicode.IsSpace(l.peek(0)) {
+			l.advance()
+		} else if l.peek(0) == '/' && l.peek(1) == '/' {
+			for l.i < len(l.src) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		} else {
+			break
+		}
+	}
+	pos := Pos{Line: l.line, Col: l.col}
+	if l.i == len(l.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	r := l.peek(0)
+	start := l.i
+	switch {
+	case unicode.IsLetter(r) || r == '_':
+		for unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0)) || l.peek(0) == '_' {
+			l.advance()
+		}
+		text := string(l.src[start:l.i])
+		if keywords[text] {
+			return token{kind: tokKeyword, text: text, pos: pos}, nil
+		}
+		return token{kind: tokIdent, text: text, pos: pos}, nil
+	case unicode.IsDigit(r):
+		for unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+		return token{kind: tokInt, text: string(l.src[start:l.i]), pos: pos}, nil
+	case r == '"':
+		l.advance()
+		for {
+			if l.i == len(l.src) || l.peek(0) == '\n' {
+				return token{}, errorf(pos, "unterminated string")
+			}
+			c := l.advance()
+			if c == '\\' && l.i < len(l.src) {
+				l.advance()
+			} else if c == '"' {
+				break
+			}
+		}
+		text := string(l.src[start:l.i])
+		if _, err := strconv.Unquote(text); err != nil {
+			return token{}, errorf(pos, "invalid string %s", text)
+		}
+		return token{kind: tokString, text: text, pos: pos}, nil
+	}
+	rest := string(l.src[l.i:])
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			for range op {
+				l.advance()
+			}
+			return token{kind: tokOp, text: op, pos: pos}, nil
+		}
+	}
+	return token{}, errorf(pos, "unexpected character %q", r)
+}
@@ -0,0 +1,296 @@
+package lang
+
+import (
+	"math/big"
+	"strconv"
+)
+
+// binary operator precedence, higher binds tighter
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, ">": 3, "<=": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+// Parse reads a program, a list of statements
+func Parse(src string) (*Block, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	prog := &Block{Pos: p.tok().pos}
+	for p.tok().kind != tokEOF {
+		stmt, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		prog.Stmts = append(prog.Stmts, stmt)
+	}
+	return prog, nil
+}
+
+func (p *parser) tok() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) peek() token {
+	if p.i+1 < len(p.tokens) {
+		return p.tokens[p.i+1]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+// is reports whether the current token is the operator or keyword text
+func (p *parser) is(text string) bool {
+	tok := p.tok()
+	return (tok.kind == tokOp || tok.kind == tokKeyword) && tok.text == text
+}
+
+func (p *parser) expect(text string) (token, error) {
+	tok := p.tok()
+	if !p.is(text) {
+		return tok, errorf(tok.pos, "expected %q, got %s", text, describe(tok))
+	}
+	p.i++
+	return tok, nil
+}
+
+func (p *parser) ident() (token, error) {
+	tok := p.tok()
+	if tok.kind != tokIdent {
+		return tok, errorf(tok.pos, "expected a name, got %s", describe(tok))
+	}
+	p.i++
+	return tok, nil
+}
+
+func describe(tok token) string {
+	if tok.kind == tokEOF {
+		return "end of input"
+	}
+	return strconv.Quote(tok.text)
+}
+
+func (p *parser) stmt() (Stmt, error) {
+	tok := p.tok()
+	switch {
+	case p.is("let"):
+		p.i++
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		return &Let{Pos: tok.pos, Name: name.text, Value: value}, nil
+	case p.is("if"):
+		return p.ifStmt()
+	case p.is("while"):
+		p.i++
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &While{Pos: tok.pos, Cond: cond, Body: body}, nil
+	case p.is("return"):
+		p.i++
+		ret := &Return{Pos: tok.pos}
+		if !p.is(";") {
+			value, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			ret.Value = value
+		}
+		_, err := p.expect(";")
+		return ret, err
+	case p.is("{"):
+		return p.block()
+	case tok.kind == tokIdent && p.peek().text == "=":
+		p.i++
+		value, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		return &Assign{Pos: tok.pos, Name: tok.text, Value: value}, nil
+	case tok.kind == tokIdent && p.peek().text == "(":
+		call, err := p.call()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(";")
+		return &CallStmt{Call: call}, err
+	}
+	return nil, errorf(tok.pos, "expected a statement, got %s", describe(tok))
+}
+
+// assignment reads "= expr;"
+func (p *parser) assignment() (Expr, error) {
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	value, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.expect(";")
+	return value, err
+}
+
+func (p *parser) ifStmt() (Stmt, error) {
+	tok, err := p.expect("if")
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &If{Pos: tok.pos, Cond: cond, Then: then}
+	if !p.is("else") {
+		return stmt, nil
+	}
+	p.i++
+	if p.is("if") {
+		stmt.Else, err = p.ifStmt()
+	} else {
+		stmt.Else, err = p.block()
+	}
+	return stmt, err
+}
+
+func (p *parser) block() (*Block, error) {
+	tok, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	block := &Block{Pos: tok.pos}
+	for !p.is("}") {
+		if p.tok().kind == tokEOF {
+			return nil, errorf(p.tok().pos, "expected \"}\", got end of input")
+		}
+		stmt, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		block.Stmts = append(block.Stmts, stmt)
+	}
+	p.i++
+	return block, nil
+}
+
+func (p *parser) expr() (Expr, error) {
+	return p.binary(1)
+}
+
+// binary parses operators of at least the given precedence, all of them
+// associate to the left
+func (p *parser) binary(prec int) (Expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.tok()
+		opPrec, ok := precedence[tok.text]
+		if tok.kind != tokOp || !ok || opPrec < prec {
+			return x, nil
+		}
+		p.i++
+		y, err := p.binary(opPrec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Pos: tok.pos, Op: tok.text, X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	tok := p.tok()
+	if p.is("!") || p.is("-") {
+		p.i++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Pos: tok.pos, Op: tok.text, X: x}, nil
+	}
+	return p.operand()
+}
+
+func (p *parser) operand() (Expr, error) {
+	tok := p.tok()
+	switch {
+	case tok.kind == tokInt:
+		p.i++
+		v, ok := new(big.Int).SetString(tok.text, 0)
+		if !ok {
+			return nil, errorf(tok.pos, "invalid number %q", tok.text)
+		}
+		return &IntLit{Pos: tok.pos, Value: v}, nil
+	case tok.kind == tokString:
+		p.i++
+		s, _ := strconv.Unquote(tok.text)
+		return &StringLit{Pos: tok.pos, Value: []byte(s)}, nil
+	case p.is("true") || p.is("false"):
+		p.i++
+		return &BoolLit{Pos: tok.pos, Value: tok.text == "true"}, nil
+	case tok.kind == tokIdent && p.peek().text == "(":
+		return p.call()
+	case tok.kind == tokIdent:
+		p.i++
+		return &Ident{Pos: tok.pos, Name: tok.text}, nil
+	case p.is("("):
+		p.i++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return x, err
+	}
+	return nil, errorf(tok.pos, "expected an expression, got %s", describe(tok))
+}
+
+func (p *parser) call() (*Call, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	call := &Call{Pos: name.pos, Name: name.text}
+	for !p.is(")") {
+		if len(call.Args) > 0 {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+	}
+	p.i++
+	return call, nil
+}
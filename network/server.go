@@ -3,7 +3,6 @@ package network
 import (
 	"blockchain/core"
 	"blockchain/crypto"
-	"blockchain/types"
 	"bytes"
 	"encoding/gob"
 	"fmt"
@@ -16,8 +15,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var DefaultBlocktime = time.Second * 5
-
 type ServerOpts struct {
 	ListenAddress string
 	NodeSeeds     []string
@@ -25,8 +22,17 @@ type ServerOpts struct {
 	PrivateKey    *crypto.PrivateKey
 	BlockTime     time.Duration
 	Logger        log.Logger
+	// DataDir keeps the chain on disk, an empty DataDir keeps it in memory
+	DataDir string
+	// Genesis defaults to core.DefaultGenesis
+	Genesis *core.Genesis
+	// TxLifetime is how long a tx that can not be included yet, like one
+	// behind a nonce gap, is kept in the pool. Zero means DefaultTxLifetime.
+	TxLifetime time.Duration
 }
 
+const DefaultTxLifetime = 10 * time.Minute
+
 type Server struct {
 	ServerOpts
 	TcpTransport *TcpTransport
@@ -41,15 +47,36 @@ type Server struct {
 	mu           sync.RWMutex
 }
 
-func NewServer(opts ServerOpts) *Server {
+func NewServer(opts ServerOpts) (*Server, error) {
+	if opts.Genesis == nil {
+		opts.Genesis = core.DefaultGenesis()
+	}
 	if opts.BlockTime == time.Duration(0) {
-		opts.BlockTime = DefaultBlocktime
+		blockTime, err := opts.Genesis.BlockDuration()
+		if err != nil {
+			return nil, err
+		}
+		opts.BlockTime = blockTime
+	}
+	if opts.TxLifetime == 0 {
+		opts.TxLifetime = DefaultTxLifetime
 	}
 	if opts.Logger == nil {
 		opts.Logger = log.NewLogfmtLogger(os.Stderr)
 		opts.Logger = log.With(opts.Logger, "node", opts.ListenAddress)
 	}
-	chain := core.NewBlockChain(opts.Logger, GenesisBlock())
+	var store core.Storage = core.NewStorage()
+	if opts.DataDir != "" {
+		fileStore, err := core.NewFileStorage(core.FileStorageOpts{Dir: opts.DataDir})
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	chain, err := core.NewBlockChain(opts.Logger, store, opts.Genesis)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Server{
 		PeerMap:     make(map[NetAddr]*TcpPeer),
@@ -75,7 +102,7 @@ func NewServer(opts ServerOpts) *Server {
 	}
 
 	// get now blockchain state
-	return s
+	return s, nil
 }
 
 // connect each other
@@ -207,7 +234,13 @@ func (s *Server) ProcessSyncBlocks(msg *SyncBlocksMessage) error {
 	s.Logger.Log("msg", "received sync block!", "blocks", msg.Blocks, "len", len(msg.Blocks))
 	var oldHeight = s.Chain.Height()
 	for _, block := range msg.Blocks {
-		err := s.Chain.AddBlockWithoutValidate(block)
+		// the peer sends its whole chain, skip what we already have
+		if s.Chain.HasBlock(block) {
+			continue
+		}
+		// synced blocks are validated and executed like any other block so
+		// a bad peer can not make our state diverge
+		err := s.Chain.AddBlock(block)
 		if err != nil {
 			return err
 		}
@@ -223,16 +256,22 @@ func (s *Server) ProcessTransaction(from NetAddr, tx *core.Transaction) error {
 		// TODO
 		return nil
 	}
+	// already included in a block
+	if _, err := s.Chain.GetReceipt(hash); err == nil {
+		return nil
+	}
 	if err := tx.Verify(); err != nil {
 		return err
 	}
 	tx.FirstSeen = time.Now().UnixNano()
+	if err := s.MemPool.Add(tx); err != nil {
+		return err
+	}
 	s.Logger.Log("msg", "transaction received and added to pool", "from", from, "hash", hash, "mempoolLen", s.MemPool.Len())
 
 	//  broadcast tx
 	go s.BroadcastTx(tx)
-
-	return s.MemPool.Add(tx)
+	return nil
 }
 
 func (s *Server) ProcessBlock(b *core.Block) error {
@@ -310,17 +349,22 @@ func (s *Server) CreateBlock() error {
 		return err
 	}
 
-	txx := s.MemPool.Pending()
-	newBlock, err := core.NewBLockFromHeader(header, txx)
+	txx, later := s.Chain.FilterTransactions(s.MemPool.Pending())
+	// whatever does not fit the block gas limit waits for the next block
+	txx, rest := core.FitGasLimit(txx, s.Chain.Genesis.BlockGasLimit())
+	keep := s.unexpired(later)
+	keep = append(keep, rest...)
+	newBlock, err := s.sealBlock(header, txx)
 	if err != nil {
-		return err
+		// the txs of a block that was not added go back to the pool
+		keep = append(keep, txx...)
 	}
-	// sign
-	if err := newBlock.Sign(*s.PrivateKey); err != nil {
-		return err
+	for _, tx := range keep {
+		if err := s.MemPool.Add(tx); err != nil {
+			s.Logger.Log("msg", "drop transaction", "hash", tx.Hash(core.TxHasher{}), "err", err)
+		}
 	}
-
-	if err := s.Chain.AddBlock(newBlock); err != nil {
+	if err != nil {
 		return err
 	}
 	// validator broadcast
@@ -328,12 +372,41 @@ func (s *Server) CreateBlock() error {
 	return nil
 }
 
-func GenesisBlock() *core.Block {
-	header := &core.Header{
-		Version:   1,
-		Height:    0,
-		DataHash:  types.Hash{},
-		TimeStamp: 0000000,
+// unexpired drops the txs of txx that waited in the pool for longer than
+// TxLifetime, a tx that can not be paid for or whose nonce gap is never
+// filled would be retried forever otherwise
+func (s *Server) unexpired(txx []*core.Transaction) []*core.Transaction {
+	now := time.Now().UnixNano()
+	keep := make([]*core.Transaction, 0, len(txx))
+	for _, tx := range txx {
+		if now-tx.FirstSeen > int64(s.TxLifetime) {
+			s.Logger.Log("msg", "drop transaction", "hash", tx.Hash(core.TxHasher{}), "err", "expired in the pool")
+			continue
+		}
+		keep = append(keep, tx)
+	}
+	return keep
+}
+
+// sealBlock builds, signs and adds the block of txx on top of header
+func (s *Server) sealBlock(header *core.Header, txx []*core.Transaction) (*core.Block, error) {
+	newBlock, err := core.NewBLockFromHeader(header, txx)
+	if err != nil {
+		return nil, err
+	}
+	// the header commits to the state and logs after executing txx, which can
+	// read the validator
+	newBlock.Validator = s.PrivateKey.PublicKey()
+	if err := s.Chain.CalculateHeader(newBlock); err != nil {
+		return nil, err
+	}
+	// sign
+	if err := newBlock.Sign(*s.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	if err := s.Chain.AddBlock(newBlock); err != nil {
+		return nil, err
 	}
-	return core.NewBlock(header, nil)
+	return newBlock, nil
 }
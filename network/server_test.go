@@ -0,0 +1,96 @@
+package network
+
+import (
+	"blockchain/core"
+	"blockchain/crypto"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore fails every write
+type failingStore struct {
+	core.Storage
+}
+
+func (failingStore) Put(*core.Block) error {
+	return errors.New("disk full")
+}
+
+func TestCreateBlockKeepsPendingTxs(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	bob := crypto.GenerateKeyPair()
+	genesis := core.DefaultGenesis()
+	genesis.Alloc = core.GenesisAlloc{alice.PublicKey().String(): 100}
+	// no key in the opts, the test creates the blocks instead of the loop
+	s, err := NewServer(ServerOpts{Logger: log.NewNopLogger(), Genesis: genesis})
+	assert.Nil(t, err)
+	validator := crypto.GenerateKeyPair()
+	s.PrivateKey = &validator
+
+	now := time.Now().UnixNano()
+	transfer := func(nonce uint64, firstSeen int64) *core.Transaction {
+		tx := core.NewTransaction(nil)
+		tx.To = bob.PublicKey()
+		tx.Value = 10
+		tx.Nonce = nonce
+		tx.GasLimit = 100
+		tx.SetFirstSeen(now + firstSeen)
+		assert.Nil(t, tx.Sign(&alice))
+		return tx
+	}
+	// nonces 0 to 2 arrive out of order, 5 is in the future and 0 again with
+	// a different value is a replay
+	future := transfer(5, 0)
+	replay := transfer(0, 4)
+	replay.Value = 20
+	assert.Nil(t, replay.Sign(&alice))
+	for _, tx := range []*core.Transaction{transfer(2, 1), transfer(1, 2), transfer(0, 3), replay, future} {
+		assert.Nil(t, s.MemPool.Add(tx))
+	}
+
+	assert.Nil(t, s.CreateBlock())
+	assert.Equal(t, uint32(1), s.Chain.Height())
+	assert.Equal(t, uint64(30), s.Chain.AccountState.Balance(bob.PublicKey()))
+	assert.Equal(t, 1, s.MemPool.Len())
+	assert.True(t, s.MemPool.Has(future.Hash(core.TxHasher{})))
+
+	// a block that can not be added hands its txs back to the pool
+	next := transfer(3, 5)
+	assert.Nil(t, s.MemPool.Add(next))
+	s.Chain.Store = failingStore{s.Chain.Store}
+	assert.NotNil(t, s.CreateBlock())
+	assert.Equal(t, uint32(1), s.Chain.Height())
+	assert.Equal(t, 2, s.MemPool.Len())
+	assert.True(t, s.MemPool.Has(next.Hash(core.TxHasher{})))
+	assert.True(t, s.MemPool.Has(future.Hash(core.TxHasher{})))
+}
+
+func TestCreateBlockExpiresTxs(t *testing.T) {
+	alice := crypto.GenerateKeyPair()
+	s, err := NewServer(ServerOpts{Logger: log.NewNopLogger(), TxLifetime: time.Minute})
+	assert.Nil(t, err)
+	validator := crypto.GenerateKeyPair()
+	s.PrivateKey = &validator
+
+	// alice can not pay either transfer, the old one is given up
+	unpaid := func(nonce uint64, age time.Duration) *core.Transaction {
+		tx := core.NewTransaction(nil)
+		tx.To = validator.PublicKey()
+		tx.Value = 10
+		tx.Nonce = nonce
+		tx.SetFirstSeen(time.Now().Add(-age).UnixNano())
+		assert.Nil(t, tx.Sign(&alice))
+		return tx
+	}
+	old, fresh := unpaid(0, 2*time.Minute), unpaid(1, 0)
+	assert.Nil(t, s.MemPool.Add(old))
+	assert.Nil(t, s.MemPool.Add(fresh))
+
+	assert.Nil(t, s.CreateBlock())
+	assert.Equal(t, 1, s.MemPool.Len())
+	assert.True(t, s.MemPool.Has(fresh.Hash(core.TxHasher{})))
+}
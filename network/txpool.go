@@ -3,6 +3,7 @@ package network
 import (
 	"blockchain/core"
 	"blockchain/types"
+	"fmt"
 	"sort"
 )
 
@@ -50,7 +51,14 @@ func NewTxPool() *TxPool {
 	}
 }
 
+// Add admits tx to the pool, a deploy is rejected when its code does not pass
+// core.VerifyCode
 func (p *TxPool) Add(tx *core.Transaction) error {
+	if tx.IsDeploy() {
+		if err := core.VerifyCode(tx.Data); err != nil {
+			return fmt.Errorf("tx %s: invalid contract code: %w", tx.Hash(core.TxHasher{}), err)
+		}
+	}
 	hash := tx.Hash(core.TxHasher{})
 	p.Transactions[hash] = tx
 	return nil
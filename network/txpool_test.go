@@ -14,16 +14,28 @@ func TestTxPool(t *testing.T) {
 
 func TestTxPoolAddTx(t *testing.T) {
 	p := NewTxPool()
-	tx := core.NewTransaction([]byte("hello"))
+	tx := core.NewTransaction([]byte{0x20, 0x01, 0x1d})
 	assert.Nil(t, p.Add(tx))
 	assert.Equal(t, p.Len(), 1)
+
+	// a deploy of code that does not verify is rejected
+	var verifyErr *core.VerifyError
+	assert.ErrorAs(t, p.Add(core.NewTransaction([]byte("hello"))), &verifyErr)
+	assert.ErrorAs(t, p.Add(core.NewTransaction([]byte{0x19})), &verifyErr)
+	assert.Equal(t, p.Len(), 1)
+
+	// the data of a transfer is not code
+	transfer := core.NewTransaction([]byte("hello"))
+	transfer.To = core.ContractAddress(tx.From, 0)
+	assert.Nil(t, p.Add(transfer))
+	assert.Equal(t, p.Len(), 2)
 }
 
 func TestTxPoolSort(t *testing.T) {
 	p := NewTxPool()
 
 	for i := 0; i < 1000; i++ {
-		tx := core.NewTransaction([]byte("foo"))
+		tx := core.NewTransaction([]byte{0x1c})
 		assert.Nil(t, p.Add(tx))
 	}
 	s := p.SortedTxx()
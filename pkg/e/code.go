@@ -6,4 +6,12 @@ var (
 	ErrBlockKnown = errors.New("block already known")
 
 	ErrBlockUnKnown = errors.New("block not found")
+
+	ErrTxUnKnown = errors.New("transaction not found")
+
+	ErrKeyUnKnown = errors.New("state key not found")
+
+	ErrInvalidNonce = errors.New("invalid nonce")
+
+	ErrInsufficientBalance = errors.New("insufficient balance")
 )